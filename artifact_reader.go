@@ -0,0 +1,427 @@
+package mender_rest_api_client
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedCompression = errors.New("Unsupported artifact compression")
+
+// Mender artifact read from local file
+type LocalArtifact struct {
+	// Name, DeviceTypesCompatible, Info of first payload, Files of all
+	// payloads and Size are filled, Signed is set when manifest.sig exists
+	ArtifactInfo
+	FormatVersion int
+	Provides      map[string]string
+	Depends       map[string]interface{}
+	Payloads      []ArtifactPayload
+	// names of state scripts
+	Scripts []string
+	// raw manifest and its signature, used for signature verification
+	Manifest  []byte
+	Signature []byte
+}
+
+type ArtifactPayload struct {
+	Type           string
+	Provides       map[string]string
+	Depends        map[string]interface{}
+	ClearsProvides []string
+	MetaData       map[string]interface{}
+	Files          []ArtifactFile
+}
+
+type artifactVersion struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+type artifactHeaderInfo struct {
+	// version 3
+	Payloads []struct {
+		Type *string `json:"type"`
+	} `json:"payloads"`
+	Provides map[string]string      `json:"artifact_provides"`
+	Depends  map[string]interface{} `json:"artifact_depends"`
+	// version 2
+	Updates []struct {
+		Type string `json:"type"`
+	} `json:"updates"`
+	DeviceTypesCompatible []string `json:"device_types_compatible"`
+	ArtifactName          string   `json:"artifact_name"`
+}
+
+type artifactTypeInfo struct {
+	Type           *string                `json:"type"`
+	Provides       map[string]string      `json:"artifact_provides"`
+	Depends        map[string]interface{} `json:"artifact_depends"`
+	ClearsProvides []string               `json:"clears_artifact_provides"`
+}
+
+// reader counting read bytes
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// Read mender artifact file, see ReadArtifact
+func ReadArtifactFile(filePath string) (LocalArtifact, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return LocalArtifact{}, fmt.Errorf("Failed to read file: %v", err)
+	}
+	defer f.Close()
+
+	return ReadArtifact(f)
+}
+
+// Read mender artifact of format version 2 or 3 and verify manifest checksums.
+// Only gzip compressed and uncompressed headers and payloads are supported
+func ReadArtifact(r io.Reader) (LocalArtifact, error) {
+	artifact := LocalArtifact{
+		Provides: map[string]string{},
+		Depends:  map[string]interface{}{},
+		Payloads: []ArtifactPayload{},
+		Scripts:  []string{},
+	}
+	artifact.DeviceTypesCompatible = []string{}
+	artifact.Files = []ArtifactFile{}
+
+	// checksums of read entries by manifest name
+	sums := map[string]string{}
+	var manifest map[string]string
+
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return artifact, err
+		}
+
+		switch {
+		case hdr.Name == "version":
+			data, sum, err := readWithChecksum(tr)
+			if err != nil {
+				return artifact, err
+			}
+			sums[hdr.Name] = sum
+
+			var version artifactVersion
+			if err = json.Unmarshal(data, &version); err != nil {
+				return artifact, fmt.Errorf("Invalid version: %v", err)
+			}
+			if version.Format != "mender" || (version.Version != 2 && version.Version != 3) {
+				return artifact, fmt.Errorf("Unsupported artifact format %v version %v", version.Format, version.Version)
+			}
+			artifact.FormatVersion = version.Version
+
+		case hdr.Name == "manifest":
+			if artifact.Manifest, err = ioutil.ReadAll(tr); err != nil {
+				return artifact, err
+			}
+			if manifest, err = parseManifest(artifact.Manifest); err != nil {
+				return artifact, err
+			}
+
+		case hdr.Name == "manifest.sig":
+			if artifact.Signature, err = ioutil.ReadAll(tr); err != nil {
+				return artifact, err
+			}
+			artifact.Signed = true
+
+		case strings.HasPrefix(hdr.Name, "header.tar"):
+			h := sha256.New()
+			if err = readArtifactHeader(hdr.Name, io.TeeReader(tr, h), &artifact); err != nil {
+				return artifact, err
+			}
+			// hash rest of compressed stream
+			if _, err = io.Copy(h, tr); err != nil {
+				return artifact, err
+			}
+			sums[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+
+		case strings.HasPrefix(hdr.Name, "data/"):
+			if err = readArtifactData(hdr.Name, tr, &artifact, sums); err != nil {
+				return artifact, err
+			}
+		}
+	}
+
+	if artifact.FormatVersion == 0 {
+		return artifact, fmt.Errorf("Missing version")
+	}
+	if manifest == nil {
+		return artifact, fmt.Errorf("Missing manifest")
+	}
+
+	for name, sum := range sums {
+		expected, ok := manifest[name]
+		if !ok {
+			return artifact, fmt.Errorf("%w: %v missing in manifest", ErrArtifactVerification, name)
+		}
+		if expected != sum {
+			return artifact, fmt.Errorf("%w: checksum of %v is %v, expected %v", ErrArtifactVerification, name, sum, expected)
+		}
+	}
+	for name := range manifest {
+		// augmented header is listed in augmented manifest only
+		if _, ok := sums[name]; !ok && !strings.HasPrefix(name, "header-augment") {
+			return artifact, fmt.Errorf("%w: %v listed in manifest is missing", ErrArtifactVerification, name)
+		}
+	}
+
+	for _, p := range artifact.Payloads {
+		artifact.Files = append(artifact.Files, p.Files...)
+	}
+	if len(artifact.Payloads) > 0 {
+		artifact.Info.TypeInfo.Type = artifact.Payloads[0].Type
+	}
+	artifact.Size = counter.n
+
+	return artifact, nil
+}
+
+// Parse "<sha256>  <name>" lines
+func parseManifest(data []byte) (map[string]string, error) {
+	manifest := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return manifest, fmt.Errorf("Invalid manifest line %q", scanner.Text())
+		}
+		manifest[fields[1]] = strings.ToLower(fields[0])
+	}
+
+	return manifest, scanner.Err()
+}
+
+func readWithChecksum(r io.Reader) ([]byte, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return data, "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:]), nil
+}
+
+func decompressArtifactEntry(name string, r io.Reader) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(name, ".tar"):
+		return r, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrUnsupportedCompression, name)
+}
+
+// Get payload index from "headers/0000/..." or "data/0000.tar.gz" names
+func payloadIndex(name string) (int, error) {
+	index, err := strconv.Atoi(strings.SplitN(name, ".", 2)[0])
+	if err != nil {
+		return 0, fmt.Errorf("Invalid payload index in %v", name)
+	}
+
+	return index, nil
+}
+
+func readArtifactHeader(name string, r io.Reader, artifact *LocalArtifact) error {
+	data, err := decompressArtifactEntry(name, r)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(data)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case hdr.Name == "header-info":
+			if err = parseHeaderInfo(content, artifact); err != nil {
+				return err
+			}
+
+		case strings.HasPrefix(hdr.Name, "scripts/"):
+			artifact.Scripts = append(artifact.Scripts, path.Base(hdr.Name))
+
+		case strings.HasPrefix(hdr.Name, "headers/"):
+			parts := strings.Split(hdr.Name, "/")
+			if len(parts) != 3 {
+				continue
+			}
+			index, err := payloadIndex(parts[1])
+			if err != nil {
+				return err
+			}
+			if index >= len(artifact.Payloads) {
+				return fmt.Errorf("Header of unknown payload %v", hdr.Name)
+			}
+			payload := &artifact.Payloads[index]
+
+			switch parts[2] {
+			case "type-info":
+				var typeInfo artifactTypeInfo
+				if err = json.Unmarshal(content, &typeInfo); err != nil {
+					return fmt.Errorf("Invalid %v: %v", hdr.Name, err)
+				}
+				if typeInfo.Type != nil {
+					payload.Type = *typeInfo.Type
+				}
+				if typeInfo.Provides != nil {
+					payload.Provides = typeInfo.Provides
+				}
+				if typeInfo.Depends != nil {
+					payload.Depends = typeInfo.Depends
+				}
+				payload.ClearsProvides = typeInfo.ClearsProvides
+			case "meta-data":
+				if len(bytes.TrimSpace(content)) == 0 {
+					continue
+				}
+				if err = json.Unmarshal(content, &payload.MetaData); err != nil {
+					return fmt.Errorf("Invalid %v: %v", hdr.Name, err)
+				}
+			}
+		}
+	}
+}
+
+func parseHeaderInfo(content []byte, artifact *LocalArtifact) error {
+	var info artifactHeaderInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return fmt.Errorf("Invalid header-info: %v", err)
+	}
+
+	if artifact.FormatVersion == 2 {
+		artifact.Name = info.ArtifactName
+		artifact.DeviceTypesCompatible = info.DeviceTypesCompatible
+		artifact.Provides["artifact_name"] = info.ArtifactName
+		artifact.Depends["device_type"] = info.DeviceTypesCompatible
+		for _, u := range info.Updates {
+			artifact.Payloads = append(artifact.Payloads, newArtifactPayload(u.Type))
+		}
+		return nil
+	}
+
+	if info.Provides != nil {
+		artifact.Provides = info.Provides
+	}
+	if info.Depends != nil {
+		artifact.Depends = info.Depends
+	}
+	artifact.Name = artifact.Provides["artifact_name"]
+
+	if deviceTypes, ok := artifact.Depends["device_type"].([]interface{}); ok {
+		for _, d := range deviceTypes {
+			if s, ok := d.(string); ok {
+				artifact.DeviceTypesCompatible = append(artifact.DeviceTypesCompatible, s)
+			}
+		}
+	}
+
+	for _, p := range info.Payloads {
+		payloadType := ""
+		if p.Type != nil {
+			payloadType = *p.Type
+		}
+		artifact.Payloads = append(artifact.Payloads, newArtifactPayload(payloadType))
+	}
+
+	return nil
+}
+
+func newArtifactPayload(payloadType string) ArtifactPayload {
+	return ArtifactPayload{
+		Type:     payloadType,
+		Provides: map[string]string{},
+		Depends:  map[string]interface{}{},
+		MetaData: map[string]interface{}{},
+		Files:    []ArtifactFile{},
+	}
+}
+
+// Read payload files of "data/0000.tar.gz" entry
+func readArtifactData(name string, r io.Reader, artifact *LocalArtifact, sums map[string]string) error {
+	index, err := payloadIndex(strings.TrimPrefix(name, "data/"))
+	if err != nil {
+		return err
+	}
+	if index >= len(artifact.Payloads) {
+		return fmt.Errorf("Data of unknown payload %v", name)
+	}
+	payload := &artifact.Payloads[index]
+
+	data, err := decompressArtifactEntry(name, r)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(data)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		h := sha256.New()
+		size, err := io.Copy(h, tr)
+		if err != nil {
+			return err
+		}
+
+		sum := hex.EncodeToString(h.Sum(nil))
+		sums[fmt.Sprintf("data/%04d/%v", index, hdr.Name)] = sum
+
+		payload.Files = append(payload.Files, ArtifactFile{
+			Name:     hdr.Name,
+			Checksum: sum,
+			Size:     int(size),
+			Date:     hdr.ModTime.UTC().Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package mender_rest_api_client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadArtifact(t *testing.T) {
+	content := []byte("rootfs content")
+	artifact := buildTestArtifact(t, map[string][]byte{"rootfs": content})
+
+	a, e := ReadArtifact(bytes.NewReader(artifact))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if a.FormatVersion != 3 || a.Name != "release-1" || a.Signed || a.Size != int64(len(artifact)) {
+		t.Error(a)
+	}
+
+	if len(a.DeviceTypesCompatible) != 1 || a.DeviceTypesCompatible[0] != "raspberrypi4" {
+		t.Error(a.DeviceTypesCompatible)
+	}
+
+	if len(a.Payloads) != 1 || a.Payloads[0].Type != "rootfs-image" || a.Info.TypeInfo.Type != "rootfs-image" {
+		t.Error(a.Payloads)
+	}
+
+	if len(a.Files) != 1 || a.Files[0].Name != "rootfs" || a.Files[0].Checksum != sha256Hex(content) ||
+		a.Files[0].Size != len(content) {
+		t.Error(a.Files)
+	}
+}
+
+func TestReadArtifactFile(t *testing.T) {
+	dir, e := ioutil.TempDir("", "reader")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "artifact.mender")
+	if e = ioutil.WriteFile(filePath, buildTestArtifact(t, map[string][]byte{"rootfs": []byte("data")}), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	a, e := ReadArtifactFile(filePath)
+	if e != nil || a.Name != "release-1" {
+		t.Error(e, a.Name)
+	}
+
+	if _, e = ReadArtifactFile(filePath + ".missing"); e == nil {
+		t.Error(e)
+	}
+}
+
+func TestReadArtifactVersion2(t *testing.T) {
+	content := []byte("rootfs content")
+	version := []byte(`{"format": "mender", "version": 2}`)
+	header := gzipTar(t, []tarEntry{
+		{"header-info", []byte(`{"updates": [{"type": "rootfs-image"}], "device_types_compatible": ["beaglebone"], "artifact_name": "release-2"}`)},
+		{"scripts/ArtifactInstall_Enter_00", []byte("#!/bin/sh")},
+		{"headers/0000/type-info", []byte(`{"type": "rootfs-image"}`)},
+	})
+	data := gzipTar(t, []tarEntry{{"rootfs", content}})
+	manifest := fmt.Sprintf("%v  version\n%v  header.tar.gz\n%v  data/0000/rootfs\n",
+		sha256Hex(version), sha256Hex(header), sha256Hex(content))
+
+	a, e := ReadArtifact(bytes.NewReader(plainTar(t, []tarEntry{
+		{"version", version},
+		{"manifest", []byte(manifest)},
+		{"header.tar.gz", header},
+		{"data/0000.tar.gz", data},
+	})))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if a.FormatVersion != 2 || a.Name != "release-2" || a.Provides["artifact_name"] != "release-2" {
+		t.Error(a)
+	}
+
+	if len(a.DeviceTypesCompatible) != 1 || a.DeviceTypesCompatible[0] != "beaglebone" {
+		t.Error(a.DeviceTypesCompatible)
+	}
+
+	if len(a.Scripts) != 1 || a.Scripts[0] != "ArtifactInstall_Enter_00" {
+		t.Error(a.Scripts)
+	}
+}
+
+func TestReadArtifactChecksumMismatch(t *testing.T) {
+	version := []byte(`{"format": "mender", "version": 3}`)
+	header := gzipTar(t, []tarEntry{
+		{"header-info", []byte(`{"payloads": [{"type": "rootfs-image"}], "artifact_provides": {"artifact_name": "release-1"}}`)},
+	})
+	data := gzipTar(t, []tarEntry{{"rootfs", []byte("modified")}})
+	manifest := fmt.Sprintf("%v  version\n%v  header.tar.gz\n%v  data/0000/rootfs\n",
+		sha256Hex(version), sha256Hex(header), sha256Hex([]byte("original")))
+
+	_, e := ReadArtifact(bytes.NewReader(plainTar(t, []tarEntry{
+		{"version", version},
+		{"manifest", []byte(manifest)},
+		{"header.tar.gz", header},
+		{"data/0000.tar.gz", data},
+	})))
+	if !errors.Is(e, ErrArtifactVerification) {
+		t.Error(e)
+	}
+}
+
+func TestReadArtifactMissingManifest(t *testing.T) {
+	_, e := ReadArtifact(bytes.NewReader(plainTar(t, []tarEntry{
+		{"version", []byte(`{"format": "mender", "version": 3}`)},
+	})))
+	if e == nil {
+		t.Error(e)
+	}
+}
+
+func TestReadArtifactUnsupportedCompression(t *testing.T) {
+	_, e := ReadArtifact(bytes.NewReader(plainTar(t, []tarEntry{
+		{"version", []byte(`{"format": "mender", "version": 3}`)},
+		{"header.tar.xz", []byte("xz")},
+	})))
+	if !errors.Is(e, ErrUnsupportedCompression) {
+		t.Error(e)
+	}
+}
@@ -0,0 +1,262 @@
+package mender_rest_api_client
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+var ErrArtifactNotSigned = errors.New("Artifact is not signed")
+var ErrInvalidSignature = errors.New("Invalid artifact signature")
+
+// ASN.1 encoded ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// Parse PEM encoded RSA or ECDSA private key in PKCS#1, PKCS#8 or SEC 1 form
+func ParsePrivateKey(pemData []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode PEM private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse private key: %v", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported private key type %T", key)
+}
+
+// Parse PEM encoded RSA or ECDSA public key in PKIX or PKCS#1 form
+func ParsePublicKey(pemData []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode PEM public key")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %v", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported public key type %T", key)
+}
+
+// Sign artifact file, existing signature is replaced and srcPath can be
+// the same as dstPath. See SignArtifact
+func SignArtifactFile(srcPath, dstPath string, key crypto.Signer) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	// destination is replaced only when signing succeeds
+	dst, err := ioutil.TempFile(filepath.Dir(dstPath), filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = dst.Chmod(info.Mode().Perm())
+	if err == nil {
+		err = SignArtifact(dst, src, key)
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), dstPath)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+	}
+
+	return err
+}
+
+// Copy artifact from reader to writer with manifest.sig signing its manifest,
+// existing signature is replaced. RSA keys sign with PKCS#1 v1.5 and ECDSA
+// keys with concatenated r and s, both over SHA-256 of manifest
+func SignArtifact(dst io.Writer, src io.Reader, key crypto.Signer) error {
+	tr := tar.NewReader(src)
+	tw := tar.NewWriter(dst)
+
+	signed := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Name == "manifest.sig" {
+			continue
+		}
+
+		if hdr.Name != "manifest" {
+			if err = writeTarEntry(tw, hdr, tr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		manifest, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		sig, err := signManifest(manifest, key)
+		if err != nil {
+			return err
+		}
+
+		if err = writeTarEntry(tw, hdr, bytes.NewReader(manifest)); err != nil {
+			return err
+		}
+		sigHdr := &tar.Header{Name: "manifest.sig", Mode: 0644, Size: int64(len(sig)), ModTime: hdr.ModTime}
+		if err = writeTarEntry(tw, sigHdr, bytes.NewReader(sig)); err != nil {
+			return err
+		}
+		signed = true
+	}
+
+	if !signed {
+		return fmt.Errorf("Missing manifest")
+	}
+
+	return tw.Close()
+}
+
+// Read artifact and verify its manifest signature with public key,
+// manifest checksums are verified by ReadArtifact
+func VerifyArtifact(r io.Reader, key crypto.PublicKey) (LocalArtifact, error) {
+	artifact, err := ReadArtifact(r)
+	if err != nil {
+		return artifact, err
+	}
+
+	if !artifact.Signed {
+		return artifact, ErrArtifactNotSigned
+	}
+
+	return artifact, verifyManifestSignature(artifact.Manifest, artifact.Signature, key)
+}
+
+// Create base64 encoded signature of manifest
+func signManifest(manifest []byte, key crypto.Signer) ([]byte, error) {
+	digest := sha256.Sum256(manifest)
+
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign manifest: %v", err)
+	}
+
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+	case *ecdsa.PublicKey:
+		var esig ecdsaSignature
+		if _, err = asn1.Unmarshal(sig, &esig); err != nil {
+			return nil, fmt.Errorf("Failed to sign manifest: %v", err)
+		}
+		// r and s are left padded to key size
+		size := ecdsaKeySize(pub)
+		r, s := esig.R.Bytes(), esig.S.Bytes()
+		sig = make([]byte, 2*size)
+		copy(sig[size-len(r):size], r)
+		copy(sig[2*size-len(s):], s)
+	default:
+		return nil, fmt.Errorf("Unsupported signing key type %T", pub)
+	}
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sig)))
+	base64.StdEncoding.Encode(encoded, sig)
+
+	return encoded, nil
+}
+
+// Verify base64 encoded signature of manifest, ECDSA signature is accepted
+// as concatenated r and s or in ASN.1 form
+func verifyManifestSignature(manifest, signature []byte, key crypto.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	digest := sha256.Sum256(manifest)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+		return nil
+
+	case *ecdsa.PublicKey:
+		size := ecdsaKeySize(pub)
+		var esig ecdsaSignature
+		if len(sig) == 2*size {
+			esig.R = new(big.Int).SetBytes(sig[:size])
+			esig.S = new(big.Int).SetBytes(sig[size:])
+		} else if _, err = asn1.Unmarshal(sig, &esig); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+		if !ecdsa.Verify(pub, digest[:], esig.R, esig.S) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported public key type %T", key)
+}
+
+func ecdsaKeySize(key *ecdsa.PublicKey) int {
+	return (key.Curve.Params().BitSize + 7) / 8
+}
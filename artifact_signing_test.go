@@ -0,0 +1,318 @@
+package mender_rest_api_client
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func testSigningKeys(t *testing.T) map[string]crypto.Signer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey}
+}
+
+func TestSignAndVerifyArtifact(t *testing.T) {
+	artifact := buildTestArtifact(t, map[string][]byte{"rootfs": []byte("content")})
+	keys := testSigningKeys(t)
+
+	for name, key := range keys {
+		var signed bytes.Buffer
+		if e := SignArtifact(&signed, bytes.NewReader(artifact), key); e != nil {
+			t.Fatal(name, e)
+		}
+
+		a, e := VerifyArtifact(bytes.NewReader(signed.Bytes()), key.Public())
+		if e != nil || !a.Signed || a.Name != "release-1" {
+			t.Error(name, e, a)
+		}
+
+		// signature made by other key
+		for other, otherKey := range keys {
+			if other == name {
+				continue
+			}
+			if _, e = VerifyArtifact(bytes.NewReader(signed.Bytes()), otherKey.Public()); e == nil {
+				t.Error(name, other, e)
+			}
+		}
+
+		// re-signing replaces signature
+		var resigned bytes.Buffer
+		if e = SignArtifact(&resigned, bytes.NewReader(signed.Bytes()), key); e != nil {
+			t.Error(e)
+		}
+		if _, e = VerifyArtifact(&resigned, key.Public()); e != nil {
+			t.Error(name, e)
+		}
+	}
+}
+
+func TestVerifyArtifactErrors(t *testing.T) {
+	artifact := buildTestArtifact(t, map[string][]byte{"rootfs": []byte("content")})
+	key := testSigningKeys(t)["ecdsa"]
+
+	if _, e := VerifyArtifact(bytes.NewReader(artifact), key.Public()); !errors.Is(e, ErrArtifactNotSigned) {
+		t.Error(e)
+	}
+
+	sig := []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 64)))
+	if e := verifyManifestSignature([]byte("manifest"), sig, key.Public()); !errors.Is(e, ErrInvalidSignature) {
+		t.Error(e)
+	}
+	if e := verifyManifestSignature([]byte("manifest"), []byte("not base64!"), key.Public()); !errors.Is(e, ErrInvalidSignature) {
+		t.Error(e)
+	}
+}
+
+func TestSignArtifactFile(t *testing.T) {
+	dir, e := ioutil.TempDir("", "signing")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "release.mender")
+	dst := filepath.Join(dir, "release-signed.mender")
+	ioutil.WriteFile(src, buildTestArtifact(t, map[string][]byte{"rootfs": []byte("content")}), 0644)
+	keys := testSigningKeys(t)
+	key := keys["rsa"]
+
+	if e = SignArtifactFile(src, dst, key); e != nil {
+		t.Fatal(e)
+	}
+
+	a, e := ReadArtifactFile(dst)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e = verifyManifestSignature(a.Manifest, a.Signature, key.Public()); e != nil {
+		t.Error(e)
+	}
+
+	// re-signing in place keeps artifact
+	otherKey := keys["ecdsa"]
+	if e = SignArtifactFile(dst, dst, otherKey); e != nil {
+		t.Fatal(e)
+	}
+	if a, e = ReadArtifactFile(dst); e != nil {
+		t.Fatal(e)
+	}
+	if e = verifyManifestSignature(a.Manifest, a.Signature, otherKey.Public()); e != nil {
+		t.Error(e)
+	}
+
+	// failed signing leaves destination untouched
+	ioutil.WriteFile(src, []byte("not an artifact"), 0644)
+	if e = SignArtifactFile(src, dst, key); e == nil {
+		t.Error(e)
+	}
+	if _, e = ReadArtifactFile(dst); e != nil {
+		t.Error(e)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Error(files)
+	}
+}
+
+func TestSignManifestECDSAPadding(t *testing.T) {
+	key := testSigningKeys(t)["ecdsa"]
+
+	// r or s shorter than key size occurs in about 1 of 128 signatures
+	for i := 0; i < 1000; i++ {
+		sig, e := signManifest([]byte("manifest"), key)
+		if e != nil {
+			t.Fatal(e)
+		}
+		raw, e := base64.StdEncoding.DecodeString(string(sig))
+		if e != nil || len(raw) != 64 {
+			t.Fatal(e, len(raw))
+		}
+		if e = verifyManifestSignature([]byte("manifest"), sig, key.Public()); e != nil {
+			t.Fatal(e)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys := testSigningKeys(t)
+	for name, key := range keys {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+		der, err = x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+		signer, e := ParsePrivateKey(privatePEM)
+		if e != nil {
+			t.Fatal(name, e)
+		}
+		public, e := ParsePublicKey(publicPEM)
+		if e != nil {
+			t.Fatal(name, e)
+		}
+
+		sig, e := signManifest([]byte("manifest"), signer)
+		if e != nil {
+			t.Error(e)
+		}
+		if e = verifyManifestSignature([]byte("manifest"), sig, public); e != nil {
+			t.Error(name, e)
+		}
+	}
+
+	rsaKey := keys["rsa"].(*rsa.PrivateKey)
+	if _, e := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})); e != nil {
+		t.Error(e)
+	}
+	if _, e := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})); e != nil {
+		t.Error(e)
+	}
+	if _, e := ParsePrivateKey([]byte("garbage")); e == nil {
+		t.Error(e)
+	}
+}
+
+func TestWriteSignedModuleImage(t *testing.T) {
+	key := testSigningKeys(t)["ecdsa"]
+
+	var buf bytes.Buffer
+	e := WriteModuleImage(&buf, ModuleImage{
+		Name:                  "app-1.0",
+		DeviceTypesCompatible: []string{"qemu"},
+		Type:                  "app",
+		Files:                 []ModuleFile{{Name: "app.bin", Content: []byte("binary")}},
+		Signer:                key,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if _, e = VerifyArtifact(&buf, key.Public()); e != nil {
+		t.Error(e)
+	}
+}
+
+func TestUploadArtifactVerifySignature(t *testing.T) {
+	artifact := buildTestArtifact(t, map[string][]byte{"rootfs": []byte("content")})
+	key := testSigningKeys(t)["rsa"]
+
+	var signed bytes.Buffer
+	if e := SignArtifact(&signed, bytes.NewReader(artifact), key); e != nil {
+		t.Fatal(e)
+	}
+
+	c := restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "artifacts"), "", 201)
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "artifacts"),
+		func(req *http.Request) (*http.Response, error) {
+			if _, err := ioutil.ReadAll(req.Body); err != nil {
+				return nil, err
+			}
+			return createdResponder(path.Join(deviceDeploymentsBasePath, "artifacts", "art1"))(req)
+		})
+
+	_, e := c.UploadArtifactStream(context.Background(), ArtifactUpload{
+		Reader:    bytes.NewReader(artifact),
+		Size:      int64(len(artifact)),
+		VerifyKey: key.Public(),
+	})
+	if !errors.Is(e, ErrArtifactNotSigned) {
+		t.Error(e)
+	}
+
+	_, e = c.UploadArtifactStream(context.Background(), ArtifactUpload{
+		Reader:    bytes.NewBuffer(signed.Bytes()),
+		Size:      int64(signed.Len()),
+		VerifyKey: key.Public(),
+	})
+	if e == nil {
+		t.Error(e)
+	}
+
+	if n := httpmock.GetTotalCallCount(); n != 0 {
+		t.Error(n)
+	}
+
+	id, e := c.UploadArtifactStream(context.Background(), ArtifactUpload{
+		Reader:    bytes.NewReader(signed.Bytes()),
+		Size:      int64(signed.Len()),
+		VerifyKey: key.Public(),
+	})
+	if e != nil || id != "art1" {
+		t.Error(e, id)
+	}
+
+	// content changed after verification
+	changed := append([]byte{}, signed.Bytes()...)
+	changed[len(changed)-1] = 1
+	_, e = c.UploadArtifactStream(context.Background(), ArtifactUpload{
+		Reader:    &rewindSwapReader{Reader: bytes.NewReader(signed.Bytes()), swap: changed},
+		Size:      int64(signed.Len()),
+		VerifyKey: key.Public(),
+	})
+	if !errors.Is(e, ErrArtifactChanged) {
+		t.Error(e)
+	}
+
+	// upload from file
+	dir, e := ioutil.TempDir("", "signing")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "release.mender")
+	ioutil.WriteFile(file, signed.Bytes(), 0644)
+	if id, e = c.UploadArtifactFile(file, "desc", key.Public()); e != nil || id != "art1" {
+		t.Error(e, id)
+	}
+
+	ioutil.WriteFile(file, artifact, 0644)
+	if _, e = c.UploadArtifactFile(file, "desc", key.Public()); !errors.Is(e, ErrArtifactNotSigned) {
+		t.Error(e)
+	}
+}
+
+// reader serving other content after it is rewound to start
+type rewindSwapReader struct {
+	*bytes.Reader
+	swap []byte
+}
+
+func (r *rewindSwapReader) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart && r.swap != nil {
+		r.Reader, r.swap = bytes.NewReader(r.swap), nil
+	}
+
+	return r.Reader.Seek(offset, whence)
+}
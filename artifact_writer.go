@@ -0,0 +1,557 @@
+package mender_rest_api_client
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// names of state scripts allowed in artifact, e.g. "ArtifactInstall_Enter_00"
+var artifactScriptName = regexp.MustCompile(
+	`^(ArtifactInstall|ArtifactReboot|ArtifactCommit|ArtifactRollback|ArtifactRollbackReboot|ArtifactFailure)_(Enter|Leave|Error)_[0-9]{2}(_\S+)?$`)
+
+// Module image update artifact of format version 3 with single payload
+type ModuleImage struct {
+	Name                  string
+	DeviceTypesCompatible []string
+	// update module type, e.g. "single-file"
+	Type string
+	// artifact provides and depends besides artifact_name and device_type
+	Provides map[string]string
+	Depends  map[string]interface{}
+	// payload provides, defaults to "rootfs-image.<type>.version" set to Name
+	PayloadProvides map[string]string
+	PayloadDepends  map[string]interface{}
+	// defaults to "rootfs-image.<type>.*"
+	ClearsProvides []string
+	// optional meta-data passed to update module
+	MetaData map[string]interface{}
+	Files    []ModuleFile
+	Scripts  []StateScript
+	// optional key signing manifest, see SignArtifact
+	Signer crypto.Signer
+}
+
+// File of module image payload, content is read each time artifact is
+// written from Open, Path or Content, first one set is used
+type ModuleFile struct {
+	// name in payload
+	Name string
+	// opens file content, Size must be exact number of bytes read
+	Open func() (io.ReadCloser, error)
+	Size int64
+	// file read when artifact is written
+	Path string
+	// in-memory file content
+	Content []byte
+	// defaults to 0644 and current time, or to file mode and time of Path
+	Mode    int64
+	ModTime time.Time
+}
+
+type StateScript struct {
+	// e.g. "ArtifactInstall_Enter_00"
+	Name    string
+	Content []byte
+}
+
+// Create module image of single-file update module installing file
+// from srcPath to destDir on device
+func NewSingleFileImage(name string, deviceTypes []string, srcPath, destDir string) (ModuleImage, error) {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return ModuleImage{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return ModuleImage{}, fmt.Errorf("%v is not a regular file", srcPath)
+	}
+
+	filename := filepath.Base(srcPath)
+
+	return ModuleImage{
+		Name:                  name,
+		DeviceTypesCompatible: deviceTypes,
+		Type:                  "single-file",
+		Files: []ModuleFile{
+			{Name: filename, Path: srcPath},
+			{Name: "dest_dir", Content: []byte(destDir)},
+			{Name: "filename", Content: []byte(filename)},
+			{Name: "permissions", Content: []byte(strconv.FormatInt(int64(info.Mode().Perm()), 8))},
+		},
+	}, nil
+}
+
+// Create module image of directory update module replacing content of destDir
+// on device with content of srcDir
+func NewDirectoryImage(name string, deviceTypes []string, srcDir, destDir string) (ModuleImage, error) {
+	info, err := os.Stat(srcDir)
+	if err != nil {
+		return ModuleImage{}, err
+	}
+	if !info.IsDir() {
+		return ModuleImage{}, fmt.Errorf("%v is not a directory", srcDir)
+	}
+
+	// size of tar is found by writing it without file content
+	counter := &countingWriter{}
+	if err = writeDirectoryTar(counter, srcDir, false); err != nil {
+		return ModuleImage{}, err
+	}
+
+	return ModuleImage{
+		Name:                  name,
+		DeviceTypesCompatible: deviceTypes,
+		Type:                  "directory",
+		Files: []ModuleFile{
+			{Name: "update.tar", Open: directoryTarOpener(srcDir), Size: counter.n},
+			{Name: "dest_dir", Content: []byte(destDir)},
+		},
+	}, nil
+}
+
+// Create module image of script update module running script on device
+func NewScriptImage(name string, deviceTypes []string, scriptPath string) (ModuleImage, error) {
+	info, err := os.Stat(scriptPath)
+	if err != nil {
+		return ModuleImage{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return ModuleImage{}, fmt.Errorf("%v is not a regular file", scriptPath)
+	}
+
+	return ModuleImage{
+		Name:                  name,
+		DeviceTypesCompatible: deviceTypes,
+		Type:                  "script",
+		Files: []ModuleFile{
+			{Name: filepath.Base(scriptPath), Path: scriptPath, Mode: 0755},
+		},
+	}, nil
+}
+
+// Write module image artifact to file, see WriteModuleImage
+func WriteModuleImageFile(filePath string, image ModuleImage) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = WriteModuleImage(f, image)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+	}
+
+	return err
+}
+
+// Write module image as mender artifact of format version 3, payload data
+// is stored in temporary file because manifest precedes it in artifact
+func WriteModuleImage(w io.Writer, image ModuleImage) error {
+	if err := image.validate(); err != nil {
+		return err
+	}
+
+	data, err := ioutil.TempFile("", "mender-artifact-data-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+
+	// checksums of payload files by manifest name
+	sums, err := writeModuleData(data, image.Files)
+	if err != nil {
+		return err
+	}
+
+	header, err := image.header()
+	if err != nil {
+		return err
+	}
+
+	version, err := json.Marshal(artifactVersion{Format: "mender", Version: 3})
+	if err != nil {
+		return err
+	}
+
+	manifest := bytes.Buffer{}
+	fmt.Fprintf(&manifest, "%v  version\n", sha256Sum(version))
+	fmt.Fprintf(&manifest, "%v  header.tar.gz\n", sha256Sum(header))
+	for _, f := range image.Files {
+		fmt.Fprintf(&manifest, "%v  data/0000/%v\n", sums[f.Name], f.Name)
+	}
+
+	dataSize, err := data.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = data.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	type entry struct {
+		name string
+		r    io.Reader
+		size int64
+	}
+	entries := []entry{
+		{"version", bytes.NewReader(version), int64(len(version))},
+		{"manifest", bytes.NewReader(manifest.Bytes()), int64(manifest.Len())},
+	}
+	if image.Signer != nil {
+		sig, err := signManifest(manifest.Bytes(), image.Signer)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{"manifest.sig", bytes.NewReader(sig), int64(len(sig))})
+	}
+	entries = append(entries,
+		entry{"header.tar.gz", bytes.NewReader(header), int64(len(header))},
+		entry{"data/0000.tar.gz", data, dataSize},
+	)
+
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		if err = writeTarEntry(tw, &tar.Header{Name: entry.name, Mode: 0644, Size: entry.size, ModTime: time.Now()}, entry.r); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func (image ModuleImage) validate() error {
+	if image.Name == "" || len(image.DeviceTypesCompatible) == 0 {
+		return fmt.Errorf("Artifact name and device types are required")
+	}
+	if image.Type == "" {
+		return fmt.Errorf("Update module type is required")
+	}
+
+	names := map[string]bool{}
+	for _, f := range image.Files {
+		if f.Name == "" || filepath.Base(f.Name) != f.Name {
+			return fmt.Errorf("Invalid payload file name %q", f.Name)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("Duplicate payload file %v", f.Name)
+		}
+		names[f.Name] = true
+		if f.Open == nil && f.Path == "" && f.Content == nil {
+			return fmt.Errorf("Payload file %v has no content", f.Name)
+		}
+	}
+
+	for _, s := range image.Scripts {
+		if !artifactScriptName.MatchString(s.Name) {
+			return fmt.Errorf("Invalid state script name %q", s.Name)
+		}
+	}
+
+	return nil
+}
+
+// Create gzip compressed header tar
+func (image ModuleImage) header() ([]byte, error) {
+	provides := map[string]string{}
+	for k, v := range image.Provides {
+		provides[k] = v
+	}
+	provides["artifact_name"] = image.Name
+
+	depends := map[string]interface{}{}
+	for k, v := range image.Depends {
+		depends[k] = v
+	}
+	depends["device_type"] = image.DeviceTypesCompatible
+
+	payloadProvides := image.PayloadProvides
+	if payloadProvides == nil {
+		payloadProvides = map[string]string{fmt.Sprintf("rootfs-image.%v.version", image.Type): image.Name}
+	}
+	clearsProvides := image.ClearsProvides
+	if clearsProvides == nil {
+		clearsProvides = []string{fmt.Sprintf("rootfs-image.%v.*", image.Type)}
+	}
+
+	headerInfo, err := json.Marshal(map[string]interface{}{
+		"payloads":          []map[string]string{{"type": image.Type}},
+		"artifact_provides": provides,
+		"artifact_depends":  depends,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	typeInfo := map[string]interface{}{
+		"type":                     image.Type,
+		"artifact_provides":        payloadProvides,
+		"clears_artifact_provides": clearsProvides,
+	}
+	if image.PayloadDepends != nil {
+		typeInfo["artifact_depends"] = image.PayloadDepends
+	}
+	typeInfoData, err := json.Marshal(typeInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	add := func(name string, mode int64, content []byte) error {
+		return writeTarEntry(tw, &tar.Header{Name: name, Mode: mode, Size: int64(len(content)), ModTime: time.Now()},
+			bytes.NewReader(content))
+	}
+
+	if err = add("header-info", 0644, headerInfo); err != nil {
+		return nil, err
+	}
+	for _, s := range image.Scripts {
+		if err = add("scripts/"+s.Name, 0755, s.Content); err != nil {
+			return nil, err
+		}
+	}
+	if err = add("headers/0000/type-info", 0644, typeInfoData); err != nil {
+		return nil, err
+	}
+	if len(image.MetaData) > 0 {
+		metaData, err := json.Marshal(image.MetaData)
+		if err != nil {
+			return nil, err
+		}
+		if err = add("headers/0000/meta-data", 0644, metaData); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Write gzip compressed tar of payload files, returns their checksums by name
+func writeModuleData(w io.Writer, files []ModuleFile) (map[string]string, error) {
+	sums := map[string]string{}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, f := range files {
+		sum, err := writeModuleFile(tw, f)
+		if err != nil {
+			return sums, fmt.Errorf("Failed to write payload file %v: %v", f.Name, err)
+		}
+		sums[f.Name] = sum
+	}
+
+	if err := tw.Close(); err != nil {
+		return sums, err
+	}
+
+	return sums, gz.Close()
+}
+
+// Write payload file to tar, file is closed before return. Returns checksum
+// of the file
+func writeModuleFile(tw *tar.Writer, f ModuleFile) (string, error) {
+	hdr := &tar.Header{Name: f.Name, Mode: f.Mode, Size: f.Size, ModTime: f.ModTime}
+
+	var r io.ReadCloser
+	switch {
+	case f.Open != nil:
+		var err error
+		if r, err = f.Open(); err != nil {
+			return "", err
+		}
+	case f.Path != "":
+		file, err := os.Open(f.Path)
+		if err != nil {
+			return "", err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return "", err
+		}
+		r = file
+		hdr.Size = info.Size()
+		if hdr.Mode == 0 {
+			hdr.Mode = int64(info.Mode().Perm())
+		}
+		if hdr.ModTime.IsZero() {
+			hdr.ModTime = info.ModTime()
+		}
+	default:
+		r = ioutil.NopCloser(bytes.NewReader(f.Content))
+		hdr.Size = int64(len(f.Content))
+	}
+	defer r.Close()
+
+	if hdr.Mode == 0 {
+		hdr.Mode = 0644
+	}
+	if hdr.ModTime.IsZero() {
+		hdr.ModTime = time.Now()
+	}
+
+	h := sha256.New()
+	if err := writeTarEntry(tw, hdr, io.TeeReader(r, h)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Write tar entry with content of exactly hdr.Size bytes
+func writeTarEntry(tw *tar.Writer, hdr *tar.Header, r io.Reader) error {
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	n, err := io.Copy(tw, r)
+	if err != nil {
+		return err
+	}
+	if n != hdr.Size {
+		return fmt.Errorf("Read %v bytes, expected %v", n, hdr.Size)
+	}
+
+	return nil
+}
+
+func sha256Sum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writer counting written bytes
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.n += int64(len(b))
+	return len(b), nil
+}
+
+// Get opener of directory tar, the tar is written by goroutine which stops
+// when returned reader is closed
+func directoryTarOpener(dir string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeDirectoryTar(pw, dir, true))
+		}()
+
+		return pr, nil
+	}
+}
+
+// Write tar of directory content with paths relative to the directory, zeros
+// are written instead of file content when withContent is false
+func writeDirectoryTar(w io.Writer, dir string, withContent bool) error {
+	tw := tar.NewWriter(w)
+
+	paths := []string{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if !info.Mode().IsRegular() {
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !withContent {
+			err = writeTarEntry(tw, hdr, io.LimitReader(zeroReader{}, hdr.Size))
+		} else {
+			err = writeTarFile(tw, hdr, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, hdr *tar.Header, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeTarEntry(tw, hdr, f)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
@@ -0,0 +1,263 @@
+package mender_rest_api_client
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestWriteModuleImage(t *testing.T) {
+	image := ModuleImage{
+		Name:                  "app-1.0",
+		DeviceTypesCompatible: []string{"raspberrypi4", "beaglebone"},
+		Type:                  "app",
+		Provides:              map[string]string{"artifact_group": "apps"},
+		Depends:               map[string]interface{}{"rootfs-image.version": "os-2"},
+		MetaData:              map[string]interface{}{"restart": true},
+		Files: []ModuleFile{
+			{Name: "app.bin", Content: []byte("binary")},
+			{Name: "config", Content: []byte("key=value")},
+		},
+		Scripts: []StateScript{
+			{Name: "ArtifactInstall_Enter_00", Content: []byte("#!/bin/sh\nexit 0\n")},
+		},
+	}
+
+	var buf bytes.Buffer
+	if e := WriteModuleImage(&buf, image); e != nil {
+		t.Fatal(e)
+	}
+
+	a, e := ReadArtifact(bytes.NewReader(buf.Bytes()))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if a.FormatVersion != 3 || a.Name != "app-1.0" || a.Provides["artifact_group"] != "apps" ||
+		a.Depends["rootfs-image.version"] != "os-2" {
+		t.Error(a)
+	}
+
+	if strings.Join(a.DeviceTypesCompatible, ",") != "raspberrypi4,beaglebone" {
+		t.Error(a.DeviceTypesCompatible)
+	}
+
+	if len(a.Scripts) != 1 || a.Scripts[0] != "ArtifactInstall_Enter_00" {
+		t.Error(a.Scripts)
+	}
+
+	if len(a.Payloads) != 1 {
+		t.Fatal(a.Payloads)
+	}
+
+	p := a.Payloads[0]
+	if p.Type != "app" || p.Provides["rootfs-image.app.version"] != "app-1.0" ||
+		len(p.ClearsProvides) != 1 || p.ClearsProvides[0] != "rootfs-image.app.*" || p.MetaData["restart"] != true {
+		t.Error(p)
+	}
+
+	if len(p.Files) != 2 || p.Files[0].Name != "app.bin" || p.Files[0].Checksum != sha256Hex([]byte("binary")) ||
+		p.Files[1].Name != "config" || p.Files[1].Size != len("key=value") {
+		t.Error(p.Files)
+	}
+}
+
+func TestWriteModuleImageInvalid(t *testing.T) {
+	devices := []string{"qemu"}
+	short := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("abc")), nil
+	}
+
+	invalid := []ModuleImage{
+		{DeviceTypesCompatible: devices, Type: "app"},
+		{Name: "app", DeviceTypesCompatible: devices},
+		{Name: "app", DeviceTypesCompatible: devices, Type: "app", Scripts: []StateScript{{Name: "Download_Enter_00"}}},
+		{Name: "app", DeviceTypesCompatible: devices, Type: "app", Files: []ModuleFile{{Name: "dir/file"}}},
+		// content shorter than size
+		{Name: "app", DeviceTypesCompatible: devices, Type: "app", Files: []ModuleFile{{Name: "file", Size: 4, Open: short}}},
+	}
+	for i, image := range invalid {
+		if e := WriteModuleImage(ioutil.Discard, image); e == nil {
+			t.Error(i, e)
+		}
+	}
+}
+
+func TestNewSingleFileImage(t *testing.T) {
+	dir, e := ioutil.TempDir("", "writer")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.conf")
+	if e = ioutil.WriteFile(src, []byte("conf"), 0600); e != nil {
+		t.Fatal(e)
+	}
+
+	image, e := NewSingleFileImage("conf-1", []string{"qemu"}, src, "/etc/app")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	artifactPath := filepath.Join(dir, "conf.mender")
+	if e = WriteModuleImageFile(artifactPath, image); e != nil {
+		t.Fatal(e)
+	}
+
+	a, e := ReadArtifactFile(artifactPath)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	files := map[string]ArtifactFile{}
+	for _, f := range a.Files {
+		files[f.Name] = f
+	}
+	if a.Info.TypeInfo.Type != "single-file" || len(files) != 4 ||
+		files["app.conf"].Checksum != sha256Hex([]byte("conf")) ||
+		files["dest_dir"].Checksum != sha256Hex([]byte("/etc/app")) ||
+		files["filename"].Checksum != sha256Hex([]byte("app.conf")) ||
+		files["permissions"].Checksum != sha256Hex([]byte("600")) {
+		t.Error(a)
+	}
+}
+
+func TestNewDirectoryImage(t *testing.T) {
+	dir, e := ioutil.TempDir("", "writer")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "www")
+	os.MkdirAll(filepath.Join(src, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "index.html"), []byte("<html></html>"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "css", "style.css"), []byte("body {}"), 0644)
+
+	image, e := NewDirectoryImage("www-1", []string{"qemu"}, src, "/var/www")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var buf bytes.Buffer
+	if e = WriteModuleImage(&buf, image); e != nil {
+		t.Fatal(e)
+	}
+
+	a, e := ReadArtifact(&buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if a.Info.TypeInfo.Type != "directory" || len(a.Files) != 2 || a.Files[0].Name != "update.tar" {
+		t.Fatal(a)
+	}
+
+	var update bytes.Buffer
+	if e = writeDirectoryTar(&update, src, true); e != nil {
+		t.Fatal(e)
+	}
+	if a.Files[0].Checksum != sha256Hex(update.Bytes()) {
+		t.Error(a.Files[0])
+	}
+
+	// image content is read again on each write
+	buf.Reset()
+	if e = WriteModuleImage(&buf, image); e != nil {
+		t.Fatal(e)
+	}
+	if b, e := ReadArtifact(&buf); e != nil || len(b.Files) != 2 || b.Files[0].Checksum != a.Files[0].Checksum ||
+		b.Files[1].Checksum != a.Files[1].Checksum {
+		t.Error(e, b)
+	}
+
+	names := []string{}
+	tr := tar.NewReader(&update)
+	for {
+		hdr, e := tr.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			t.Fatal(e)
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "css/,css/style.css,index.html" {
+		t.Error(names)
+	}
+}
+
+func TestNewScriptImage(t *testing.T) {
+	dir, e := ioutil.TempDir("", "writer")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "fix.sh")
+	ioutil.WriteFile(src, []byte("#!/bin/sh\n"), 0644)
+
+	image, e := NewScriptImage("fix-1", []string{"qemu"}, src)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var buf bytes.Buffer
+	if e = WriteModuleImage(&buf, image); e != nil {
+		t.Fatal(e)
+	}
+
+	a, e := ReadArtifact(&buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if a.Info.TypeInfo.Type != "script" || len(a.Files) != 1 || a.Files[0].Name != "fix.sh" {
+		t.Error(a)
+	}
+}
+
+func TestUploadModuleImage(t *testing.T) {
+	var buf bytes.Buffer
+	e := WriteModuleImage(&buf, ModuleImage{
+		Name:                  "app-1.0",
+		DeviceTypesCompatible: []string{"qemu"},
+		Type:                  "app",
+		Files:                 []ModuleFile{{Name: "app.bin", Content: []byte("binary")}},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	c := restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "artifacts"), "", 201)
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "artifacts"),
+		func(req *http.Request) (*http.Response, error) {
+			f, _, err := req.FormFile("artifact")
+			if err != nil {
+				t.Error(err)
+				return httpmock.NewStringResponse(400, ""), nil
+			}
+			if a, err := ReadArtifact(f); err != nil || a.Name != "app-1.0" {
+				t.Error(err, a.Name)
+			}
+			return createdResponder(path.Join(deviceDeploymentsBasePath, "artifacts", "art1"))(req)
+		})
+
+	id, e := c.UploadArtifactStream(context.Background(), ArtifactUpload{
+		Reader: bytes.NewReader(buf.Bytes()),
+		Size:   int64(buf.Len()),
+	})
+	if e != nil || id != "art1" {
+		t.Error(e, id)
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/nandra/mender-server-rest-go-client
 
-require github.com/go-resty/resty/v2 v2.3.0 // indirect
+require (
+	github.com/go-resty/resty/v2 v2.3.0
+	github.com/jarcoal/httpmock v1.1.0
+)
 
 go 1.14
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -53,7 +54,13 @@ type DevicesCount struct {
 func checkAndReturnError(r *resty.Response, e error) error {
 	// check response error
 	if r.IsError() {
-		return fmt.Errorf("Error response:%v", r.StatusCode())
+		var body struct {
+			Error string `json:"error"`
+		}
+		// error message is optional
+		json.Unmarshal(r.Body(), &body)
+
+		return &ResponseError{StatusCode: r.StatusCode(), Message: body.Error}
 	}
 	// check error
 	if e != nil {
@@ -79,6 +86,24 @@ func (c *Client) ListDevices() (ListDevices, error) {
 	return devices, nil
 }
 
+// List one page of devices with their authentication status
+func (c *Client) listDevicesPage(page, perPage int) ([]Device, error) {
+	var devices []Device = []Device{}
+	resp, err := c.client.R().
+		SetQueryParam("page", strconv.Itoa(page)).
+		SetQueryParam("per_page", strconv.Itoa(perPage)).
+		Get(path.Join(deviceAuthBasePath, "devices"))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return devices, err
+	}
+
+	if err = json.Unmarshal(resp.Body(), &devices); err != nil {
+		return devices, err
+	}
+
+	return devices, nil
+}
+
 // Submit a preauthorized device.
 // TODO: implement
 func (c *Client) Preauthorize() error {
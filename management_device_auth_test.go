@@ -1,6 +1,7 @@
 package mender_rest_api_client
 
 import (
+	"net/http"
 	"path"
 	"testing"
 
@@ -25,6 +26,16 @@ func restartHttpMock(action, path, jsonResponse string, response int) *Client {
 	return c
 }
 
+// Respond with body to first page request and with empty list to following pages
+func firstPageResponder(status int, body string) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		if page := req.URL.Query().Get("page"); page != "" && page != "1" {
+			return httpmock.NewStringResponse(200, `[]`), nil
+		}
+		return httpmock.NewStringResponse(status, body), nil
+	}
+}
+
 func TestListDevices(t *testing.T) {
 	c := restartHttpMock("GET", path.Join(deviceAuthBasePath, "devices"), `[
 		{
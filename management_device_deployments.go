@@ -1,10 +1,13 @@
 package mender_rest_api_client
 
 import (
-	"bytes"
+	"context"
+	"crypto"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
@@ -13,6 +16,13 @@ import (
 
 const deviceDeploymentsBasePath = "/api/management/v1/deployments"
 
+const deviceDeploymentsV2BasePath = "/api/management/v2/deployments"
+
+var (
+	ErrNoDevicesMatch   = errors.New("No devices match the deployment")
+	ErrArtifactNotFound = errors.New("Artifact not found")
+)
+
 type ListDeployments []struct {
 	Created      time.Time `json:"created"`
 	Status       string    `json:"status"`
@@ -24,6 +34,20 @@ type ListDeployments []struct {
 	Retries      int       `json:"retries"`
 }
 
+// Query parameters of deployments listing, zero values are not sent
+type ListDeploymentsOptions struct {
+	// "pending", "inprogress" or "finished"
+	Status string
+	// deployment name or artifact name
+	Search        string
+	CreatedBefore time.Time
+	CreatedAfter  time.Time
+	// order by creation time, "asc" or "desc"
+	Sort    string
+	Page    int
+	PerPage int
+}
+
 type DeploymentStatistics struct {
 	Success          int `json:"success"`
 	Pending          int `json:"pending"`
@@ -63,11 +87,64 @@ type DeploymentStatusList []struct {
 	Substate   string    `json:"substate"`
 }
 
+type DeviceDeploymentHistory []struct {
+	ID         string `json:"id"`
+	Deployment struct {
+		ID           string    `json:"id"`
+		Name         string    `json:"name"`
+		ArtifactName string    `json:"artifact_name"`
+		Status       string    `json:"status"`
+		Created      time.Time `json:"created"`
+	} `json:"deployment"`
+	Device struct {
+		ID         string    `json:"id"`
+		Status     string    `json:"status"`
+		Substate   string    `json:"substate"`
+		DeviceType string    `json:"device_type"`
+		Created    time.Time `json:"created"`
+		Finished   time.Time `json:"finished"`
+		Log        bool      `json:"log"`
+	} `json:"device"`
+}
+
+// Query parameters of device deployment history, zero values are not sent
+type DeviceDeploymentHistoryOptions struct {
+	// device deployment status, e.g. "failure" or "success"
+	Status  string
+	Page    int
+	PerPage int
+}
+
 type GroupDeployment struct {
 	Name         string `json:"name"`
 	ArtifactName string `json:"artifact_name"`
 }
 
+type DeploymentPhase struct {
+	// percentage of devices, can be omitted in last phase to take remaining devices
+	BatchSize int `json:"batch_size,omitempty"`
+	// required for all phases except the first one
+	StartTs *time.Time `json:"start_ts,omitempty"`
+}
+
+type DeploymentRequest struct {
+	Name              string                 `json:"name"`
+	ArtifactName      string                 `json:"artifact_name"`
+	Devices           []string               `json:"devices,omitempty"`
+	Retries           int                    `json:"retries,omitempty"`
+	MaxDevices        int                    `json:"max_devices,omitempty"`
+	Phases            []DeploymentPhase      `json:"phases,omitempty"`
+	ForceInstallation bool                   `json:"force_installation,omitempty"`
+	UpdateControlMap  map[string]interface{} `json:"update_control_map,omitempty"`
+}
+
+// deployment of devices selected by server
+type targetedDeployment struct {
+	DeploymentRequest
+	FilterId   string `json:"filter_id,omitempty"`
+	AllDevices bool   `json:"all_devices,omitempty"`
+}
+
 type ListReleases []struct {
 	Name      string `json:"name"`
 	Artifacts []struct {
@@ -82,17 +159,20 @@ type ListReleases []struct {
 				Type string `json:"type"`
 			} `json:"type_info"`
 		} `json:"info"`
-		Files []struct {
-			Name     string `json:"name"`
-			Checksum string `json:"checksum"`
-			Size     int    `json:"size"`
-			Date     string `json:"date"`
-		} `json:"files"`
+		Files    []ArtifactFile `json:"files"`
 		Metadata struct {
 		} `json:"metadata"`
 	} `json:"artifacts"`
 }
 
+// payload file of artifact, checksum is hex encoded sha256
+type ArtifactFile struct {
+	Name     string `json:"name"`
+	Checksum string `json:"checksum"`
+	Size     int    `json:"size"`
+	Date     string `json:"date"`
+}
+
 type ArtifactInfo struct {
 	Name                  string    `json:"name"`
 	Description           string    `json:"description"`
@@ -105,14 +185,16 @@ type ArtifactInfo struct {
 			Type string `json:"type"`
 		} `json:"type_info"`
 	} `json:"info"`
-	Files []struct {
-		Name     string `json:"name"`
-		Checksum string `json:"checksum"`
-		Size     int    `json:"size"`
-		Date     string `json:"date"`
-	} `json:"files"`
+	Files    []ArtifactFile `json:"files"`
 	Metadata struct {
 	} `json:"metadata"`
+	// size of artifact file
+	Size int64 `json:"size"`
+}
+
+type ArtifactLink struct {
+	URI    string    `json:"uri"`
+	Expire time.Time `json:"expire"`
 }
 
 type StorageUsage struct {
@@ -120,12 +202,44 @@ type StorageUsage struct {
 	Usage int `json:"usage"`
 }
 
-// Find all deployments
-// TODO: add support for queries
-func (c *Client) ListDeployments() (ListDeployments, error) {
+func (o *ListDeploymentsOptions) queryParams() url.Values {
+	params := url.Values{}
+	if o == nil {
+		return params
+	}
+
+	if o.Status != "" {
+		params.Set("status", o.Status)
+	}
+	if o.Search != "" {
+		params.Set("search", o.Search)
+	}
+	if !o.CreatedBefore.IsZero() {
+		params.Set("created_before", strconv.FormatInt(o.CreatedBefore.Unix(), 10))
+	}
+	if !o.CreatedAfter.IsZero() {
+		params.Set("created_after", strconv.FormatInt(o.CreatedAfter.Unix(), 10))
+	}
+	if o.Sort != "" {
+		params.Set("sort", o.Sort)
+	}
+	if o.Page > 0 {
+		params.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage > 0 {
+		params.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+
+	return params
+}
+
+// Find deployments, opts can be nil
+func (c *Client) ListDeployments(opts *ListDeploymentsOptions) (ListDeployments, error) {
 	var list ListDeployments = ListDeployments{}
-	resp, err := c.client.R().Get(path.Join(deviceDeploymentsBasePath, "deployments"))
-	if err != nil {
+	resp, err := c.client.R().
+		SetQueryParamsFromValues(opts.queryParams()).
+		Get(path.Join(deviceDeploymentsBasePath, "deployments"))
+	if err = checkAndReturnError(resp, err); err != nil {
 		return list, err
 	}
 
@@ -135,8 +249,9 @@ func (c *Client) ListDeployments() (ListDeployments, error) {
 	return list, nil
 }
 
-// Create a deployment
-func (c *Client) CreateDeployment(deploymentName, artifactName string, devices []string, retries int) error {
+// Create a deployment, returns id of the new deployment. Request is sent
+// as is, use CreateDeploymentFromRequest for client-side validation
+func (c *Client) CreateDeployment(deploymentName, artifactName string, devices []string, retries int) (string, error) {
 
 	type Deployment struct {
 		Name         string   `json:"name"`
@@ -155,19 +270,49 @@ func (c *Client) CreateDeployment(deploymentName, artifactName string, devices [
 	// marshal deployment to json
 	d, err := json.Marshal(deployment)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	return c.postDeployment(path.Join(deviceDeploymentsBasePath, "deployments"), d)
+}
+
+// Create a deployment for devices listed in request, returns id of the new deployment
+func (c *Client) CreateDeploymentFromRequest(req DeploymentRequest) (string, error) {
+	if len(req.Devices) == 0 {
+		return "", fmt.Errorf("Deployment has no devices")
+	}
+
+	if err := req.Validate(); err != nil {
+		return "", err
 	}
 
-	_, err = c.client.R().SetBody(d).Post(path.Join(deviceDeploymentsBasePath, "deployments"))
+	// marshal deployment to json
+	d, err := json.Marshal(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return c.postDeployment(path.Join(deviceDeploymentsBasePath, "deployments"), d)
+}
+
+// Create a deployment for a group of devices, devices of request are ignored
+func (c *Client) CreateDeploymentForGroupFromRequest(groupName string, req DeploymentRequest) (string, error) {
+	req.Devices = nil
+	if err := req.Validate(); err != nil {
+		return "", err
+	}
+
+	// marshal deployment to json
+	d, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	return c.postDeployment(path.Join(deviceDeploymentsBasePath, "deployments/group", groupName), d)
 }
 
-// Create a deployment for a group of devices
-func (c *Client) CreateDeploymentForGroup(deploymentName, artifactName, groupName string) error {
+// Create a deployment for a group of devices, returns id of the new deployment
+func (c *Client) CreateDeploymentForGroup(deploymentName, artifactName, groupName string) (string, error) {
 
 	deployment := GroupDeployment{
 		Name:         deploymentName,
@@ -177,12 +322,102 @@ func (c *Client) CreateDeploymentForGroup(deploymentName, artifactName, groupNam
 	// marshal deployment to json
 	d, err := json.Marshal(deployment)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	return c.postDeployment(path.Join(deviceDeploymentsBasePath, "deployments/group", groupName), d)
+}
+
+// Create a deployment for devices matching saved filter, devices are resolved by server.
+// Devices of request are ignored, returns id of the new deployment
+func (c *Client) CreateDeploymentForFilter(filterId string, req DeploymentRequest) (string, error) {
+	if filterId == "" {
+		return "", fmt.Errorf("Filter id is empty")
+	}
+
+	return c.createDeploymentV2(targetedDeployment{DeploymentRequest: req, FilterId: filterId})
+}
+
+// Create a deployment for all devices, devices of request are ignored.
+// Returns id of the new deployment
+func (c *Client) CreateDeploymentForAllDevices(req DeploymentRequest) (string, error) {
+	return c.createDeploymentV2(targetedDeployment{DeploymentRequest: req, AllDevices: true})
+}
+
+func (c *Client) createDeploymentV2(deployment targetedDeployment) (string, error) {
+	deployment.Devices = nil
+	if err := deployment.Validate(); err != nil {
+		return "", err
 	}
 
-	_, err = c.client.R().SetBody(d).Post(path.Join(deviceDeploymentsBasePath, "deployments/group", groupName))
+	// marshal deployment to json
+	d, err := json.Marshal(deployment)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	return c.postDeployment(path.Join(deviceDeploymentsV2BasePath, "deployments"), d)
+}
+
+func (c *Client) postDeployment(url string, deployment []byte) (string, error) {
+	resp, err := c.client.R().SetBody(deployment).Post(url)
+	if err = checkAndReturnError(resp, err); err != nil {
+		return "", classifyError(err, map[int]error{
+			http.StatusConflict:            ErrNoDevicesMatch,
+			http.StatusUnprocessableEntity: ErrArtifactNotFound,
+		})
+	}
+
+	return idFromLocation(resp)
+}
+
+// Check deployment request before it is sent to server
+func (r DeploymentRequest) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("Deployment name is empty")
+	}
+	if r.ArtifactName == "" {
+		return fmt.Errorf("Artifact name is empty")
+	}
+	if r.Retries < 0 {
+		return fmt.Errorf("Invalid retries %v", r.Retries)
+	}
+	if r.MaxDevices < 0 {
+		return fmt.Errorf("Invalid max devices %v", r.MaxDevices)
+	}
+
+	total := 0
+	var lastStart time.Time
+	for i, phase := range r.Phases {
+		last := i == len(r.Phases)-1
+
+		if phase.BatchSize < 0 || phase.BatchSize > 100 || (phase.BatchSize == 0 && !last) {
+			return fmt.Errorf("Invalid batch size %v of phase %v", phase.BatchSize, i)
+		}
+		total += phase.BatchSize
+
+		if phase.StartTs == nil {
+			if i > 0 {
+				return fmt.Errorf("Missing start time of phase %v", i)
+			}
+			continue
+		}
+
+		if i > 0 && !phase.StartTs.After(lastStart) {
+			return fmt.Errorf("Start time of phase %v is not after previous phase", i)
+		}
+		lastStart = *phase.StartTs
+	}
+
+	if len(r.Phases) > 0 {
+		// last phase without batch size takes remaining devices
+		if r.Phases[len(r.Phases)-1].BatchSize == 0 {
+			if total >= 100 {
+				return fmt.Errorf("Phases batch sizes exceed 100%%")
+			}
+		} else if total != 100 {
+			return fmt.Errorf("Phases batch sizes add up to %v%%, expected 100%%", total)
+		}
 	}
 
 	return nil
@@ -193,8 +428,8 @@ func (c *Client) ShowDeployment(deploymentId string) (DeploymentStatus, error) {
 	var stat DeploymentStatus = DeploymentStatus{}
 
 	resp, err := c.client.R().Get(path.Join(deviceDeploymentsBasePath, "deployments", deploymentId))
-	if err != nil {
-		fmt.Println("failed to read list of devices", err)
+	if err = checkAndReturnError(resp, err); err != nil {
+		return stat, err
 	}
 
 	if err = json.Unmarshal(resp.Body(), &stat); err != nil {
@@ -218,8 +453,8 @@ func (c *Client) AbortDeployment(deploymentId string) error {
 		return err
 	}
 
-	_, err = c.client.R().SetBody(a).Put(path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "status"))
-	if err != nil {
+	resp, err := c.client.R().SetBody(a).Put(path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "status"))
+	if err = checkAndReturnError(resp, err); err != nil {
 		return err
 	}
 
@@ -230,7 +465,7 @@ func (c *Client) AbortDeployment(deploymentId string) error {
 func (c *Client) DeploymentStatistics(deploymentId string) (DeploymentStatistics, error) {
 	var stat DeploymentStatistics = DeploymentStatistics{}
 	resp, err := c.client.R().Get(path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "statistics"))
-	if err != nil {
+	if err = checkAndReturnError(resp, err); err != nil {
 		return stat, err
 	}
 
@@ -244,7 +479,7 @@ func (c *Client) DeploymentStatistics(deploymentId string) (DeploymentStatistics
 func (c *Client) ListDevicesInDeployment(deploymentId string) (DeploymentStatusList, error) {
 	var list DeploymentStatusList = DeploymentStatusList{}
 	resp, err := c.client.R().Get(path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "devices"))
-	if err != nil {
+	if err = checkAndReturnError(resp, err); err != nil {
 		return list, err
 	}
 
@@ -270,14 +505,49 @@ func (c *Client) ListDevicesIDsInDeployment(deploymentId string) ([]string, erro
 }
 
 // Get the log of a selected device's deployment
-// TODO: test
-func (c *Client) GetDeploymentLogForDevice(deploymentId, deviceId string) (string, error) {
+func (c *Client) GetDeploymentLogForDevice(deploymentId, deviceId string) (DeploymentLog, error) {
 	resp, err := c.client.R().Get(path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "devices", deviceId, "log"))
-	if err != nil {
-		return "", err
+	if err = checkAndReturnError(resp, err); err != nil {
+		return DeploymentLog{Entries: []DeploymentLogEntry{}}, err
 	}
 
-	return string(resp.Body()), nil
+	return ParseDeploymentLog(string(resp.Body())), nil
+}
+
+func (o *DeviceDeploymentHistoryOptions) queryParams() url.Values {
+	params := url.Values{}
+	if o == nil {
+		return params
+	}
+
+	if o.Status != "" {
+		params.Set("status", o.Status)
+	}
+	if o.Page > 0 {
+		params.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage > 0 {
+		params.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+
+	return params
+}
+
+// Get all deployments of a device, opts can be nil
+func (c *Client) GetDeviceDeploymentHistory(deviceId string, opts *DeviceDeploymentHistoryOptions) (DeviceDeploymentHistory, error) {
+	var history DeviceDeploymentHistory = DeviceDeploymentHistory{}
+	resp, err := c.client.R().
+		SetQueryParamsFromValues(opts.queryParams()).
+		Get(path.Join(deviceDeploymentsBasePath, "deployments/devices", deviceId))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return history, err
+	}
+
+	if err = json.Unmarshal(resp.Body(), &history); err != nil {
+		return history, err
+	}
+
+	return history, nil
 }
 
 // Remove device from all deployments
@@ -322,43 +592,39 @@ func (c *Client) ListArtifacts() (ListReleases, error) {
 
 // Upload mender artifact
 func (c *Client) UploadArtifacts(artifactFilePath, artifactDescription string) error {
+	_, err := c.UploadArtifactFile(artifactFilePath, artifactDescription, nil)
+	return err
+}
 
-	artifact, err := ioutil.ReadFile(artifactFilePath)
-	if err != nil {
-		return fmt.Errorf("Failed to read file: %v", err)
-	}
+// Upload mender artifact file, signature is verified before upload when
+// verifyKey is not nil. Returns id of the new artifact
+func (c *Client) UploadArtifactFile(artifactFilePath, artifactDescription string, verifyKey crypto.PublicKey) (string, error) {
 
-	fi, err := os.Stat(artifactFilePath)
+	artifact, err := os.Open(artifactFilePath)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("Failed to read file: %v", err)
 	}
-	// get the size
-	size := fi.Size()
+	defer artifact.Close()
 
-	_, err = c.client.R().
-		SetFormData(map[string]string{
-			"size":        strconv.FormatInt(size, 10),
-			"description": artifactDescription,
-		}).
-		SetFileReader("artifact", fi.Name(), bytes.NewReader(artifact)).
-		Post(path.Join(deviceDeploymentsBasePath, "artifacts"))
+	fi, err := artifact.Stat()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
-}
-
-// TODO: implement
-func (c *Client) GenerateArtifact() error {
-	return fmt.Errorf("Not implmplemented")
+	return c.UploadArtifactStream(context.Background(), ArtifactUpload{
+		Reader:      artifact,
+		Size:        fi.Size(),
+		Filename:    fi.Name(),
+		Description: artifactDescription,
+		VerifyKey:   verifyKey,
+	})
 }
 
 // Get the details of a selected artifact
 func (c *Client) ShowArtifact(artifactId string) (ArtifactInfo, error) {
 	var artifact ArtifactInfo = ArtifactInfo{}
 	resp, err := c.client.R().Get(path.Join(deviceDeploymentsBasePath, "artifacts", artifactId))
-	if err != nil {
+	if err = checkAndReturnError(resp, err); err != nil {
 		return artifact, err
 	}
 
@@ -393,8 +659,8 @@ func (c *Client) UpdateArtifactinfo(artifactId, description string) error {
 
 // Delete the artifact
 func (c *Client) DeleteArtifact(artifactId string) error {
-	_, err := c.client.R().Delete(path.Join(deviceDeploymentsBasePath, "artifacts", artifactId))
-	if err != nil {
+	resp, err := c.client.R().Delete(path.Join(deviceDeploymentsBasePath, "artifacts", artifactId))
+	if err = checkAndReturnError(resp, err); err != nil {
 		return err
 	}
 
@@ -403,30 +669,35 @@ func (c *Client) DeleteArtifact(artifactId string) error {
 
 // Get the download link of a selected artifact
 func (c *Client) DownloadArtifact(artifactId string) (string, error) {
-	type ArtifactResult struct {
-		URI    string    `json:"uri"`
-		Expire time.Time `json:"expire"`
+	link, err := c.GetArtifactDownloadLink(artifactId)
+	if err != nil {
+		return "", err
 	}
 
-	var result ArtifactResult = ArtifactResult{}
+	return link.URI, nil
+}
+
+// Get the download link of a selected artifact with its expiration time
+func (c *Client) GetArtifactDownloadLink(artifactId string) (ArtifactLink, error) {
+	var link ArtifactLink = ArtifactLink{}
 
 	resp, err := c.client.R().Get(path.Join(deviceDeploymentsBasePath, "artifacts", artifactId, "download"))
-	if err != nil {
-		return "", err
+	if err = checkAndReturnError(resp, err); err != nil {
+		return link, err
 	}
 
-	if err = json.Unmarshal(resp.Body(), &result); err != nil {
-		return "", err
+	if err = json.Unmarshal(resp.Body(), &link); err != nil {
+		return link, err
 	}
 
-	return result.URI, nil
+	return link, nil
 }
 
 // Get storage limit and current storage usage
 func (c *Client) GetStorageUsage() (StorageUsage, error) {
 	var usage StorageUsage = StorageUsage{}
 	resp, err := c.client.R().Get(path.Join(deviceDeploymentsBasePath, "limits/storage"))
-	if err != nil {
+	if err = checkAndReturnError(resp, err); err != nil {
 		return usage, err
 	}
 
@@ -0,0 +1,293 @@
+package mender_rest_api_client
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// number of attempts to continue interrupted download
+const artifactDownloadRetries = 5
+
+// download link is refreshed when it expires sooner
+const artifactLinkExpireMargin = 10 * time.Second
+
+// delay between download attempts
+var artifactDownloadRetryDelay = time.Second
+
+var ErrArtifactVerification = errors.New("Artifact verification failed")
+
+// Download artifact to writer, interrupted transfers are resumed and expired
+// links are refreshed. Size and payload checksums listed in ArtifactInfo are
+// verified, checksums are verified only for gzip compressed and uncompressed
+// payloads. Returns number of written bytes
+func (c *Client) DownloadArtifactTo(ctx context.Context, artifactId string, w io.Writer) (int64, error) {
+	return c.downloadArtifact(ctx, artifactId, w, nil)
+}
+
+// Download artifact to file, partial file "<filePath>.part" left by interrupted
+// download is resumed. See DownloadArtifactTo
+func (c *Client) DownloadArtifactToFile(ctx context.Context, artifactId, filePath string) error {
+	partPath := filePath + ".part"
+
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	// existing content is read for verification, new content is appended
+	_, err = c.downloadArtifact(ctx, artifactId, f, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if errors.Is(err, ErrArtifactVerification) {
+		os.Remove(partPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(partPath, filePath)
+}
+
+// Download artifact to writer, content of existing reader is verified
+// as beginning of artifact and the download continues after it
+func (c *Client) downloadArtifact(ctx context.Context, artifactId string, w io.Writer, existing io.Reader) (int64, error) {
+	info, err := c.ShowArtifact(artifactId)
+	if err != nil {
+		return 0, err
+	}
+
+	verifier := newPayloadVerifier(info.Files)
+	defer verifier.abort()
+
+	var written int64
+	if existing != nil {
+		if written, err = io.Copy(verifier, existing); err != nil {
+			return written, err
+		}
+	}
+
+	link, err := c.GetArtifactDownloadLink(artifactId)
+	if err != nil {
+		return written, err
+	}
+
+	out := &trackingWriter{w: io.MultiWriter(w, verifier)}
+
+	for attempt := 0; info.Size == 0 || written < info.Size; attempt++ {
+		if !link.Expire.IsZero() && time.Until(link.Expire) < artifactLinkExpireMargin {
+			if link, err = c.GetArtifactDownloadLink(artifactId); err != nil {
+				return written, err
+			}
+		}
+
+		n, expired, err := c.fetchArtifactRange(ctx, link.URI, written, out)
+		written += n
+		if err == nil {
+			break
+		}
+
+		if out.err != nil {
+			return written, out.err
+		}
+
+		if attempt >= artifactDownloadRetries {
+			return written, fmt.Errorf("Download failed after %v attempts: %v", attempt+1, err)
+		}
+
+		if expired {
+			if link, err = c.GetArtifactDownloadLink(artifactId); err != nil {
+				return written, err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return written, ctx.Err()
+		case <-time.After(artifactDownloadRetryDelay):
+		}
+	}
+
+	if info.Size > 0 && written != info.Size {
+		return written, fmt.Errorf("%w: size %v, expected %v", ErrArtifactVerification, written, info.Size)
+	}
+
+	return written, verifier.Close()
+}
+
+// Get artifact content from offset, returns number of written bytes
+// and whether download link expired
+func (c *Client) fetchArtifactRange(ctx context.Context, uri string, offset int64, w io.Writer) (int64, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req = req.WithContext(ctx)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.client.GetClient().Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// range not supported, skip already written content
+		if offset > 0 {
+			if _, err = io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+				return 0, false, err
+			}
+		}
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// nothing left to download
+		return 0, false, nil
+	case http.StatusForbidden, http.StatusGone:
+		return 0, true, fmt.Errorf("Download link expired, status %v", resp.StatusCode)
+	default:
+		return 0, false, fmt.Errorf("Storage error response:%v", resp.StatusCode)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+
+	return n, false, err
+}
+
+// writer remembering first write error
+type trackingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackingWriter) Write(b []byte) (int, error) {
+	n, err := t.w.Write(b)
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+
+	return n, err
+}
+
+// Streaming checker of payload checksums, written data is artifact file
+type payloadVerifier struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newPayloadVerifier(files []ArtifactFile) *payloadVerifier {
+	pr, pw := io.Pipe()
+	v := &payloadVerifier{pw: pw, done: make(chan error, 1)}
+
+	go func() {
+		err := verifyPayloads(pr, files)
+		// drain rest of artifact
+		io.Copy(ioutil.Discard, pr)
+		v.done <- err
+	}()
+
+	return v
+}
+
+func (v *payloadVerifier) Write(b []byte) (int, error) {
+	return v.pw.Write(b)
+}
+
+// Finish verification of whole artifact
+func (v *payloadVerifier) Close() error {
+	v.pw.Close()
+	return <-v.done
+}
+
+// Stop verification of incomplete artifact
+func (v *payloadVerifier) abort() {
+	v.pw.CloseWithError(io.ErrUnexpectedEOF)
+}
+
+// Compare sha256 of payload files with expected checksums
+func verifyPayloads(r io.Reader, files []ArtifactFile) error {
+	expected := map[string]string{}
+	for _, f := range files {
+		expected[f.Name] = strings.ToLower(f.Checksum)
+	}
+
+	skipped := false
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrArtifactVerification, err)
+		}
+
+		if !strings.HasPrefix(hdr.Name, "data/") {
+			continue
+		}
+
+		var data io.Reader
+		switch {
+		case strings.HasSuffix(hdr.Name, ".tar.gz"):
+			gz, err := gzip.NewReader(tr)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrArtifactVerification, err)
+			}
+			data = gz
+		case strings.HasSuffix(hdr.Name, ".tar"):
+			data = tr
+		default:
+			// compression not supported by standard library
+			skipped = true
+			continue
+		}
+
+		payload := tar.NewReader(data)
+		for {
+			fileHdr, err := payload.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrArtifactVerification, err)
+			}
+
+			h := sha256.New()
+			if _, err = io.Copy(h, payload); err != nil {
+				return fmt.Errorf("%w: %v", ErrArtifactVerification, err)
+			}
+
+			name := path.Base(fileHdr.Name)
+			checksum, ok := expected[name]
+			if !ok {
+				continue
+			}
+			if sum := hex.EncodeToString(h.Sum(nil)); sum != checksum {
+				return fmt.Errorf("%w: checksum of %v is %v, expected %v", ErrArtifactVerification, name, sum, checksum)
+			}
+			delete(expected, name)
+		}
+	}
+
+	if !skipped && len(expected) > 0 {
+		return fmt.Errorf("%w: %v payload files missing", ErrArtifactVerification, len(expected))
+	}
+
+	return nil
+}
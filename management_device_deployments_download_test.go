@@ -0,0 +1,202 @@
+package mender_rest_api_client
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+// Create format version 3 artifact with single gzip compressed payload
+func buildTestArtifact(t *testing.T, files map[string][]byte) []byte {
+	header := gzipTar(t, []tarEntry{
+		{"header-info", []byte(`{"payloads": [{"type": "rootfs-image"}], "artifact_provides": {"artifact_name": "release-1"}, "artifact_depends": {"device_type": ["raspberrypi4"]}}`)},
+		{"headers/0000/type-info", []byte(`{"type": "rootfs-image"}`)},
+		{"headers/0000/meta-data", []byte{}},
+	})
+
+	var payload []tarEntry
+	for name, content := range files {
+		payload = append(payload, tarEntry{name, content})
+	}
+	data := gzipTar(t, payload)
+
+	version := []byte(`{"format": "mender", "version": 3}`)
+	manifest := fmt.Sprintf("%v  version\n%v  header.tar.gz\n", sha256Hex(version), sha256Hex(header))
+	for _, f := range payload {
+		manifest += fmt.Sprintf("%v  data/0000/%v\n", sha256Hex(f.content), f.name)
+	}
+
+	return plainTar(t, []tarEntry{
+		{"version", version},
+		{"manifest", []byte(manifest)},
+		{"header.tar.gz", header},
+		{"data/0000.tar.gz", data},
+	})
+}
+
+type tarEntry struct {
+	name    string
+	content []byte
+}
+
+func plainTar(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), ModTime: modTime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(entry.content)
+	}
+	tw.Close()
+
+	return buf.Bytes()
+}
+
+func gzipTar(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(plainTar(t, entries))
+	gz.Close()
+
+	return buf.Bytes()
+}
+
+var modTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Register artifact info and download link responders, links point to storage
+func registerArtifactDownload(artifactId string, artifact []byte, payload []byte, storageURL string) {
+	httpmock.RegisterResponder("GET", path.Join(deviceDeploymentsBasePath, "artifacts", artifactId),
+		httpmock.NewStringResponder(200, fmt.Sprintf(`{"id": "%v", "size": %v, "files": [{"name": "rootfs", "checksum": "%v", "size": %v}]}`,
+			artifactId, len(artifact), sha256Hex(payload), len(payload))))
+
+	links := 0
+	httpmock.RegisterResponder("GET", path.Join(deviceDeploymentsBasePath, "artifacts", artifactId, "download"),
+		func(req *http.Request) (*http.Response, error) {
+			links++
+			return httpmock.NewStringResponse(200,
+				fmt.Sprintf(`{"uri": "%v/%v?link=%v", "expire": "2100-01-01T00:00:00Z"}`, storageURL, artifactId, links)), nil
+		})
+	httpmock.RegisterNoResponder(httpmock.InitialTransport.RoundTrip)
+}
+
+func TestDownloadArtifactTo(t *testing.T) {
+	retryDelay := artifactDownloadRetryDelay
+	defer func() { artifactDownloadRetryDelay = retryDelay }()
+	artifactDownloadRetryDelay = 0
+
+	payload := bytes.Repeat([]byte("rootfs"), 10000)
+	artifact := buildTestArtifact(t, map[string][]byte{"rootfs": payload})
+
+	// interrupts first transfer, rejects first link and then serves ranges
+	requests := 0
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		switch {
+		case requests == 1:
+			w.Header().Set("Content-Length", strconv.Itoa(len(artifact)))
+			w.Write(artifact[:len(artifact)/2])
+		case strings.HasSuffix(req.URL.RawQuery, "link=1"):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			var start int
+			fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-", &start)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(artifact)-1, len(artifact)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(artifact[start:])
+		}
+	}))
+	defer storage.Close()
+
+	c := restartHttpMock("GET", "/unused", "", 200)
+	registerArtifactDownload("art1", artifact, payload, storage.URL)
+
+	var out bytes.Buffer
+	n, e := c.DownloadArtifactTo(context.Background(), "art1", &out)
+	if e != nil || n != int64(len(artifact)) || !bytes.Equal(out.Bytes(), artifact) {
+		t.Error(e, n, requests)
+	}
+
+	if requests != 3 {
+		t.Error(requests)
+	}
+}
+
+func TestDownloadArtifactChecksumMismatch(t *testing.T) {
+	payload := bytes.Repeat([]byte("rootfs"), 100)
+	artifact := buildTestArtifact(t, map[string][]byte{"rootfs": payload})
+
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(artifact)
+	}))
+	defer storage.Close()
+
+	c := restartHttpMock("GET", "/unused", "", 200)
+	registerArtifactDownload("art1", artifact, []byte("other payload"), storage.URL)
+
+	_, e := c.DownloadArtifactTo(context.Background(), "art1", ioutil.Discard)
+	if !errors.Is(e, ErrArtifactVerification) {
+		t.Error(e)
+	}
+}
+
+func TestDownloadArtifactToFile(t *testing.T) {
+	payload := bytes.Repeat([]byte("rootfs"), 1000)
+	artifact := buildTestArtifact(t, map[string][]byte{"rootfs": payload})
+
+	var ranges []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ranges = append(ranges, req.Header.Get("Range"))
+		http.ServeContent(w, req, "artifact.mender", modTime, bytes.NewReader(artifact))
+	}))
+	defer storage.Close()
+
+	c := restartHttpMock("GET", "/unused", "", 200)
+	registerArtifactDownload("art1", artifact, payload, storage.URL)
+
+	dir, e := ioutil.TempDir("", "download")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	// resume partial file of previous download
+	file := filepath.Join(dir, "artifact.mender")
+	ioutil.WriteFile(file+".part", artifact[:100], 0644)
+
+	if e = c.DownloadArtifactToFile(context.Background(), "art1", file); e != nil {
+		t.Fatal(e)
+	}
+
+	data, _ := ioutil.ReadFile(file)
+	if !bytes.Equal(data, artifact) || len(ranges) != 1 || ranges[0] != "bytes=100-" {
+		t.Error(len(data), ranges)
+	}
+
+	if _, e = os.Stat(file + ".part"); !os.IsNotExist(e) {
+		t.Error(e)
+	}
+}
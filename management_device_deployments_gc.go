@@ -0,0 +1,225 @@
+package mender_rest_api_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// reasons of keeping artifact by CollectArtifactGarbage
+const (
+	KeptByDeployment = "deployment"
+	KeptByDevice     = "device"
+	KeptByRetention  = "retention"
+)
+
+// Retention policy of artifact garbage collection, artifacts used by pending
+// or in progress deployments or installed on devices are always kept
+type ArtifactGCOptions struct {
+	// keep N newest artifacts compatible with each device type, zero disables
+	KeepPerDeviceType int
+	// keep artifacts modified within duration, zero disables
+	KeepNewerThan time.Duration
+	// report artifacts without deleting them
+	DryRun bool
+}
+
+type ArtifactGCReport struct {
+	DryRun bool
+	// artifacts deleted, or to be deleted in dry run
+	Deleted []ArtifactInfo
+	// reason of keeping by artifact id
+	Kept map[string]string
+	// sum of sizes of deleted artifacts
+	ReclaimedBytes int64
+	// errors of failed deletions by artifact id
+	Failed map[string]error
+}
+
+func (r ArtifactGCReport) String() string {
+	action := "Deleted"
+	if r.DryRun {
+		action = "Would delete"
+	}
+
+	return fmt.Sprintf("%v %v artifacts reclaiming %v bytes, kept %v, failed %v",
+		action, len(r.Deleted), r.ReclaimedBytes, len(r.Kept), len(r.Failed))
+}
+
+// Delete artifacts which are not used by pending or in progress deployments,
+// not installed on any device (per inventory artifact_name) and not kept by
+// retention policy
+func (c *Client) CollectArtifactGarbage(opts ArtifactGCOptions) (ArtifactGCReport, error) {
+	report := ArtifactGCReport{
+		DryRun:  opts.DryRun,
+		Deleted: []ArtifactInfo{},
+		Kept:    map[string]string{},
+		Failed:  map[string]error{},
+	}
+
+	artifacts, err := c.listArtifactInfos()
+	if err != nil {
+		return report, err
+	}
+
+	deployed, err := c.deployedArtifactNames()
+	if err != nil {
+		return report, err
+	}
+
+	installed, err := c.installedArtifacts()
+	if err != nil {
+		return report, err
+	}
+
+	for id := range retainedArtifacts(artifacts, opts, time.Now()) {
+		report.Kept[id] = KeptByRetention
+	}
+
+	for _, a := range artifacts {
+		if deployed[a.Name] {
+			report.Kept[a.ID] = KeptByDeployment
+			continue
+		}
+
+		if installed[a.Name] {
+			report.Kept[a.ID] = KeptByDevice
+			continue
+		}
+
+		if _, ok := report.Kept[a.ID]; ok {
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := c.DeleteArtifact(a.ID); err != nil {
+				report.Failed[a.ID] = err
+				continue
+			}
+		}
+
+		report.Deleted = append(report.Deleted, a)
+		report.ReclaimedBytes += a.Size
+	}
+
+	return report, nil
+}
+
+// Get all artifacts with their sizes, all pages are fetched. Servers without
+// paginated artifact list return all artifacts at once
+func (c *Client) listArtifactInfos() ([]ArtifactInfo, error) {
+	var artifacts []ArtifactInfo = []ArtifactInfo{}
+
+	for page := 1; ; page++ {
+		var list []ArtifactInfo
+		resp, err := c.client.R().
+			SetQueryParam("page", strconv.Itoa(page)).
+			SetQueryParam("per_page", strconv.Itoa(pageSize)).
+			Get(path.Join(deviceDeploymentsBasePath, "artifacts/list"))
+		err = checkAndReturnError(resp, err)
+		if respErr, ok := err.(*ResponseError); ok && respErr.StatusCode == http.StatusNotFound && page == 1 {
+			resp, err = c.client.R().Get(path.Join(deviceDeploymentsBasePath, "artifacts"))
+			if err = checkAndReturnError(resp, err); err != nil {
+				return artifacts, err
+			}
+
+			err = json.Unmarshal(resp.Body(), &artifacts)
+			return artifacts, err
+		}
+		if err != nil {
+			return artifacts, err
+		}
+
+		if err = json.Unmarshal(resp.Body(), &list); err != nil {
+			return artifacts, err
+		}
+
+		if len(list) == 0 {
+			break
+		}
+
+		artifacts = append(artifacts, list...)
+	}
+
+	return artifacts, nil
+}
+
+// Get names of artifacts used by pending or in progress deployments
+func (c *Client) deployedArtifactNames() (map[string]bool, error) {
+	names := map[string]bool{}
+
+	for _, status := range []string{"pending", "inprogress"} {
+		opts := ListDeploymentsOptions{Status: status, PerPage: pageSize}
+		for opts.Page = 1; ; opts.Page++ {
+			deployments, err := c.ListDeployments(&opts)
+			if err != nil {
+				return names, err
+			}
+
+			if len(deployments) == 0 {
+				break
+			}
+
+			for _, d := range deployments {
+				names[d.ArtifactName] = true
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// Get names of artifacts installed on devices per inventory, device type is
+// not considered so artifact is kept also for devices not reporting it
+func (c *Client) installedArtifacts() (map[string]bool, error) {
+	installed := map[string]bool{}
+
+	err := c.forEachInventoryPage(nil, func(devices DeviceInventoryList) error {
+		for i := range devices {
+			if name := devices[i].ArtifactName(); name != "" {
+				installed[name] = true
+			}
+		}
+		return nil
+	})
+
+	return installed, err
+}
+
+// Get ids of artifacts kept by retention policy, N newest artifacts are
+// counted among all artifacts compatible with device type
+func retainedArtifacts(artifacts []ArtifactInfo, opts ArtifactGCOptions, now time.Time) map[string]bool {
+	retained := map[string]bool{}
+
+	if opts.KeepNewerThan > 0 {
+		for _, a := range artifacts {
+			if now.Sub(a.Modified) < opts.KeepNewerThan {
+				retained[a.ID] = true
+			}
+		}
+	}
+
+	if opts.KeepPerDeviceType > 0 {
+		byDeviceType := map[string][]ArtifactInfo{}
+		for _, a := range artifacts {
+			for _, deviceType := range a.DeviceTypesCompatible {
+				byDeviceType[deviceType] = append(byDeviceType[deviceType], a)
+			}
+		}
+
+		for _, list := range byDeviceType {
+			sort.SliceStable(list, func(i, j int) bool {
+				return list[i].Modified.After(list[j].Modified)
+			})
+			for i := 0; i < len(list) && i < opts.KeepPerDeviceType; i++ {
+				retained[list[i].ID] = true
+			}
+		}
+	}
+
+	return retained
+}
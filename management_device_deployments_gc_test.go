@@ -0,0 +1,155 @@
+package mender_rest_api_client
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func gcTestArtifact(id, name, deviceType string, age time.Duration, size int) string {
+	return fmt.Sprintf(`{"id": "%v", "name": "%v", "device_types_compatible": ["%v"], "modified": "%v", "size": %v}`,
+		id, name, deviceType, time.Now().Add(-age).UTC().Format(time.RFC3339), size)
+}
+
+func registerGCResponders(deleted *[]string) *Client {
+	day := 24 * time.Hour
+	artifacts := "[" + strings.Join([]string{
+		gcTestArtifact("a0", "release-0", "rpi4", 40*day, 50),
+		gcTestArtifact("a1", "release-1", "rpi4", 30*day, 100),
+		gcTestArtifact("a2", "release-2", "rpi4", 20*day, 200),
+		gcTestArtifact("a3", "release-3", "rpi4", 10*day, 300),
+		gcTestArtifact("a4", "release-4", "rpi4", 1*day, 400),
+		gcTestArtifact("b1", "release-1", "bbb", 30*day, 1000),
+		gcTestArtifact("b2", "release-2", "bbb", 20*day, 2000),
+		gcTestArtifact("c5", "release-5", "qemu", 35*day, 5000),
+	}, ",") + "]"
+
+	c := restartHttpMock("GET", path.Join(deviceDeploymentsBasePath, "artifacts/list"), "", 200)
+	httpmock.RegisterResponder("GET", path.Join(deviceDeploymentsBasePath, "artifacts/list"),
+		firstPageResponder(200, artifacts))
+	httpmock.RegisterResponderWithQuery("GET", path.Join(deviceDeploymentsBasePath, "deployments"),
+		"status=pending&page=1&per_page=500", httpmock.NewStringResponder(200, `[{"artifact_name": "release-2"}]`))
+	httpmock.RegisterResponderWithQuery("GET", path.Join(deviceDeploymentsBasePath, "deployments"),
+		"status=pending&page=2&per_page=500", httpmock.NewStringResponder(200, `[]`))
+	httpmock.RegisterResponderWithQuery("GET", path.Join(deviceDeploymentsBasePath, "deployments"),
+		"status=inprogress&page=1&per_page=500", httpmock.NewStringResponder(200, `[]`))
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "devices"),
+		firstPageResponder(200, `[{"id": "d1", "attributes": [
+			{"name": "device_type", "value": "bbb", "scope": "inventory"},
+			{"name": "artifact_name", "value": "release-1", "scope": "inventory"}]},
+			{"id": "d2", "attributes": [
+			{"name": "artifact_name", "value": "release-5", "scope": "inventory"}]}]`))
+	httpmock.RegisterResponder("DELETE", `=~^`+path.Join(deviceDeploymentsBasePath, "artifacts")+`/\w+$`,
+		func(req *http.Request) (*http.Response, error) {
+			*deleted = append(*deleted, path.Base(req.URL.Path))
+			return httpmock.NewStringResponse(204, ""), nil
+		})
+
+	return c
+}
+
+func TestCollectArtifactGarbage(t *testing.T) {
+	deleted := []string{}
+	c := registerGCResponders(&deleted)
+
+	report, e := c.CollectArtifactGarbage(ArtifactGCOptions{KeepPerDeviceType: 1, KeepNewerThan: 15 * 24 * time.Hour})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// a0 is deleted, a2 and b2 are deployed, a3 and a4 are retained, release-1
+	// is installed on bbb device and release-5 on device without device type
+	sort.Strings(deleted)
+	if strings.Join(deleted, ",") != "a0" {
+		t.Error(deleted)
+	}
+	if len(report.Deleted) != 1 || report.ReclaimedBytes != 50 || len(report.Failed) != 0 {
+		t.Error(report)
+	}
+	for id, reason := range map[string]string{
+		"a2": KeptByDeployment, "b2": KeptByDeployment,
+		"a3": KeptByRetention, "a4": KeptByRetention,
+		"a1": KeptByDevice, "b1": KeptByDevice, "c5": KeptByDevice,
+	} {
+		if report.Kept[id] != reason {
+			t.Error(id, report.Kept[id])
+		}
+	}
+}
+
+func TestCollectArtifactGarbageDryRun(t *testing.T) {
+	deleted := []string{}
+	c := registerGCResponders(&deleted)
+
+	report, e := c.CollectArtifactGarbage(ArtifactGCOptions{DryRun: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(deleted) != 0 {
+		t.Error(deleted)
+	}
+	if len(report.Deleted) != 3 || report.ReclaimedBytes != 750 || !strings.Contains(report.String(), "750 bytes") {
+		t.Error(report)
+	}
+}
+
+func TestCollectArtifactGarbageErrors(t *testing.T) {
+	deleted := []string{}
+	c := registerGCResponders(&deleted)
+	httpmock.RegisterResponder("DELETE", path.Join(deviceDeploymentsBasePath, "artifacts", "a0"),
+		httpmock.NewStringResponder(500, `{"error": "storage failure"}`))
+
+	report, e := c.CollectArtifactGarbage(ArtifactGCOptions{KeepPerDeviceType: 1})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if report.Failed["a0"] == nil || len(deleted) != 1 || deleted[0] != "a3" || report.ReclaimedBytes != 300 {
+		t.Error(report, deleted)
+	}
+
+	httpmock.RegisterResponder("GET", path.Join(deviceDeploymentsBasePath, "artifacts/list"),
+		httpmock.NewStringResponder(500, `{"error": "internal"}`))
+	if _, e = c.CollectArtifactGarbage(ArtifactGCOptions{}); e == nil {
+		t.Error(e)
+	}
+}
+
+func TestCollectArtifactGarbageUnpaginated(t *testing.T) {
+	deleted := []string{}
+	c := registerGCResponders(&deleted)
+	httpmock.RegisterResponder("GET", path.Join(deviceDeploymentsBasePath, "artifacts/list"),
+		httpmock.NewStringResponder(404, `{"error": "not found"}`))
+	httpmock.RegisterResponder("GET", path.Join(deviceDeploymentsBasePath, "artifacts"),
+		httpmock.NewStringResponder(200, "["+gcTestArtifact("a0", "release-0", "rpi4", 0, 50)+"]"))
+
+	report, e := c.CollectArtifactGarbage(ArtifactGCOptions{DryRun: true})
+	if e != nil || len(report.Deleted) != 1 || report.Deleted[0].ID != "a0" {
+		t.Error(e, report)
+	}
+}
+
+func TestRetainedArtifacts(t *testing.T) {
+	now := time.Now()
+	artifacts := []ArtifactInfo{
+		{ID: "old", DeviceTypesCompatible: []string{"x", "y"}, Modified: now.Add(-3 * time.Hour)},
+		{ID: "mid", DeviceTypesCompatible: []string{"x"}, Modified: now.Add(-2 * time.Hour)},
+		{ID: "new", DeviceTypesCompatible: []string{"x"}, Modified: now.Add(-1 * time.Hour)},
+	}
+
+	retained := retainedArtifacts(artifacts, ArtifactGCOptions{KeepPerDeviceType: 1}, now)
+	if len(retained) != 2 || !retained["new"] || !retained["old"] {
+		t.Error(retained)
+	}
+
+	retained = retainedArtifacts(artifacts, ArtifactGCOptions{KeepNewerThan: 150 * time.Minute}, now)
+	if len(retained) != 2 || !retained["new"] || !retained["mid"] {
+		t.Error(retained)
+	}
+}
@@ -0,0 +1,116 @@
+package mender_rest_api_client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timestamp format of deployment log lines, e.g. "2016-03-11 13:03:17.123 +0000 UTC"
+const deploymentLogTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// levels reported by FirstError
+var deploymentLogErrorLevels = map[string]bool{
+	"error": true,
+	"fatal": true,
+	"panic": true,
+}
+
+type DeploymentLogEntry struct {
+	Timestamp time.Time
+	Level     string
+	Message   string
+}
+
+// Device deployment log, Raw is the log as returned by server
+type DeploymentLog struct {
+	Raw     string
+	Entries []DeploymentLogEntry
+}
+
+// Parse log lines in "<timestamp> <level>: <message>" format, lines without
+// timestamp are appended to message of previous entry
+func ParseDeploymentLog(raw string) DeploymentLog {
+	log := DeploymentLog{Raw: raw, Entries: []DeploymentLogEntry{}}
+
+	for _, line := range strings.Split(strings.TrimRight(raw, "\n"), "\n") {
+		entry, ok := parseDeploymentLogLine(line)
+		if ok {
+			log.Entries = append(log.Entries, entry)
+			continue
+		}
+
+		if len(log.Entries) == 0 {
+			if line != "" {
+				log.Entries = append(log.Entries, DeploymentLogEntry{Message: line})
+			}
+			continue
+		}
+
+		last := &log.Entries[len(log.Entries)-1]
+		last.Message += "\n" + line
+	}
+
+	return log
+}
+
+func parseDeploymentLogLine(line string) (DeploymentLogEntry, bool) {
+	// timestamp has 4 space separated fields
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) != 5 {
+		return DeploymentLogEntry{}, false
+	}
+
+	ts, err := time.Parse(deploymentLogTimeLayout, strings.Join(fields[:4], " "))
+	if err != nil {
+		return DeploymentLogEntry{}, false
+	}
+
+	level := fields[4]
+	message := ""
+	if i := strings.Index(level, ": "); i >= 0 {
+		level, message = level[:i], level[i+2:]
+	} else {
+		level = strings.TrimSuffix(level, ":")
+	}
+
+	return DeploymentLogEntry{Timestamp: ts, Level: level, Message: message}, true
+}
+
+func (e DeploymentLogEntry) String() string {
+	if e.Timestamp.IsZero() {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%s %s: %s", e.Timestamp.String(), e.Level, e.Message)
+}
+
+// Find index of first entry with error level
+func (l DeploymentLog) FirstError() (int, bool) {
+	for i, e := range l.Entries {
+		if deploymentLogErrorLevels[strings.ToLower(e.Level)] {
+			return i, true
+		}
+	}
+
+	return -1, false
+}
+
+// Get entry at index with up to before preceding and after following entries
+func (l DeploymentLog) Around(index, before, after int) []DeploymentLogEntry {
+	if index < 0 || index >= len(l.Entries) {
+		return []DeploymentLogEntry{}
+	}
+
+	start := index - before
+	if start < 0 {
+		start = 0
+	}
+
+	end := index + after + 1
+	if end > len(l.Entries) {
+		end = len(l.Entries)
+	}
+
+	return l.Entries[start:end]
+}
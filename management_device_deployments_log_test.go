@@ -0,0 +1,68 @@
+package mender_rest_api_client
+
+import (
+	"path"
+	"testing"
+	"time"
+)
+
+const deploymentLog = `2020-05-11 13:03:17.123 +0000 UTC info: Running Mender client version: 2.2.0
+2020-05-11 13:03:18 +0000 UTC debug: Fetching update
+2020-05-11 13:03:19 +0000 UTC info: State transition: update-fetch -> update-store
+2020-05-11 13:03:20 +0000 UTC error: Download failed:
+connection reset by peer
+2020-05-11 13:03:21 +0000 UTC info: State transition: update-store -> cleanup
+2020-05-11 13:03:22 +0000 UTC error: Update failed
+`
+
+func TestGetDeploymentLogForDevice(t *testing.T) {
+	url := path.Join(deviceDeploymentsBasePath, "deployments", "dep1", "devices", "1", "log")
+	c := restartHttpMock("GET", url, deploymentLog, 200)
+
+	l, e := c.GetDeploymentLogForDevice("dep1", "1")
+	if e != nil || l.Raw != deploymentLog || len(l.Entries) != 6 {
+		t.Fatal(e, l)
+	}
+
+	first := l.Entries[0]
+	if first.Level != "info" || first.Message != "Running Mender client version: 2.2.0" ||
+		!first.Timestamp.Equal(time.Date(2020, 5, 11, 13, 3, 17, 123000000, time.UTC)) {
+		t.Error(first)
+	}
+
+	i, ok := l.FirstError()
+	if !ok || i != 3 || l.Entries[i].Message != "Download failed:\nconnection reset by peer" {
+		t.Error(i, ok)
+	}
+
+	around := l.Around(i, 1, 1)
+	if len(around) != 3 || around[0].Message != l.Entries[2].Message || around[2].Message != l.Entries[4].Message {
+		t.Error(around)
+	}
+
+	if len(l.Around(0, 2, 0)) != 1 || len(l.Around(5, 0, 2)) != 1 || len(l.Around(6, 1, 1)) != 0 {
+		t.Error("Invalid bounds")
+	}
+
+	// error body is not returned as log
+	c = restartHttpMock("GET", url, `{"error": "log not found"}`, 404)
+	l, e = c.GetDeploymentLogForDevice("dep1", "1")
+	if e == nil || l.Raw != "" {
+		t.Error(e, l)
+	}
+}
+
+func TestParseDeploymentLogWithoutTimestamps(t *testing.T) {
+	l := ParseDeploymentLog("no timestamp\nsecond line\n")
+	if len(l.Entries) != 1 || l.Entries[0].Message != "no timestamp\nsecond line" {
+		t.Error(l)
+	}
+
+	if _, ok := l.FirstError(); ok {
+		t.Error("Error found")
+	}
+
+	if len(ParseDeploymentLog("").Entries) != 0 {
+		t.Error("Entry in empty log")
+	}
+}
@@ -0,0 +1,243 @@
+package mender_rest_api_client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func createdResponder(location string) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(201, "")
+		resp.Header.Set("Location", location)
+		return resp, nil
+	}
+}
+
+func TestCreateDeployment(t *testing.T) {
+	c := restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "deployments"), "", 201)
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "deployments"),
+		createdResponder(path.Join(deviceDeploymentsBasePath, "deployments", "dep1")))
+
+	id, e := c.CreateDeployment("release", "release-1", []string{"1"}, 0)
+	if e != nil || id != "dep1" {
+		t.Error(e, id)
+	}
+
+	// no devices
+	c = restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "deployments"), `{"error": "no devices"}`, 409)
+	_, e = c.CreateDeployment("release", "release-1", []string{"1"}, 0)
+	if !errors.Is(e, ErrNoDevicesMatch) {
+		t.Error(e)
+	}
+
+	var respErr *ResponseError
+	if !errors.As(e, &respErr) || respErr.StatusCode != 409 || respErr.Message != "no devices" {
+		t.Error(e)
+	}
+
+	// empty device list is left for server to reject
+	_, e = c.CreateDeployment("release", "release-1", nil, 0)
+	if !errors.Is(e, ErrNoDevicesMatch) {
+		t.Error(e)
+	}
+}
+
+func TestCreateDeploymentForGroup(t *testing.T) {
+	url := path.Join(deviceDeploymentsBasePath, "deployments/group", "production")
+	c := restartHttpMock("POST", url, "", 201)
+	httpmock.RegisterResponder("POST", url, createdResponder(path.Join(deviceDeploymentsBasePath, "deployments", "dep2")))
+
+	id, e := c.CreateDeploymentForGroup("release", "release-1", "production")
+	if e != nil || id != "dep2" {
+		t.Error(e, id)
+	}
+
+	// unknown artifact
+	c = restartHttpMock("POST", url, `{"error": "artifact not found"}`, 422)
+	_, e = c.CreateDeploymentForGroup("release", "release-2", "production")
+	if !errors.Is(e, ErrArtifactNotFound) {
+		t.Error(e)
+	}
+}
+
+func TestDeploymentRequestValidate(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	valid := []DeploymentRequest{
+		{Name: "r", ArtifactName: "a"},
+		{Name: "r", ArtifactName: "a", Phases: []DeploymentPhase{{BatchSize: 10}, {BatchSize: 90, StartTs: &later}}},
+		{Name: "r", ArtifactName: "a", Phases: []DeploymentPhase{{BatchSize: 10, StartTs: &now}, {StartTs: &later}}},
+	}
+	for i, r := range valid {
+		if e := r.Validate(); e != nil {
+			t.Error(i, e)
+		}
+	}
+
+	invalid := []DeploymentRequest{
+		{ArtifactName: "a"},
+		{Name: "r"},
+		{Name: "r", ArtifactName: "a", Retries: -1},
+		// don't add up to 100
+		{Name: "r", ArtifactName: "a", Phases: []DeploymentPhase{{BatchSize: 10}, {BatchSize: 80, StartTs: &later}}},
+		// nothing left for last phase
+		{Name: "r", ArtifactName: "a", Phases: []DeploymentPhase{{BatchSize: 100}, {StartTs: &later}}},
+		// missing start time
+		{Name: "r", ArtifactName: "a", Phases: []DeploymentPhase{{BatchSize: 10}, {BatchSize: 90}}},
+		// start times not increasing
+		{Name: "r", ArtifactName: "a", Phases: []DeploymentPhase{{BatchSize: 10, StartTs: &later}, {BatchSize: 90, StartTs: &now}}},
+	}
+	for i, r := range invalid {
+		if e := r.Validate(); e == nil {
+			t.Error(i, e)
+		}
+	}
+}
+
+func TestCreateDeploymentFromRequest(t *testing.T) {
+	later := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	url := path.Join(deviceDeploymentsBasePath, "deployments/group", "production")
+	c := restartHttpMock("POST", url, "", 201)
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		var body map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&body)
+
+		phases, _ := body["phases"].([]interface{})
+		if len(phases) != 2 || body["max_devices"] != 100.0 || body["force_installation"] != true ||
+			body["devices"] != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		if phases[1].(map[string]interface{})["start_ts"] != "2030-01-01T00:00:00Z" {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+
+		return createdResponder(path.Join(deviceDeploymentsBasePath, "deployments", "dep3"))(req)
+	})
+
+	id, e := c.CreateDeploymentForGroupFromRequest("production", DeploymentRequest{
+		Name:              "release",
+		ArtifactName:      "release-1",
+		MaxDevices:        100,
+		ForceInstallation: true,
+		Phases:            []DeploymentPhase{{BatchSize: 20}, {StartTs: &later}},
+	})
+	if e != nil || id != "dep3" {
+		t.Error(e, id)
+	}
+
+	// invalid request is not sent
+	_, e = c.CreateDeploymentFromRequest(DeploymentRequest{Name: "release", ArtifactName: "release-1"})
+	if e == nil {
+		t.Error(e)
+	}
+}
+
+func TestCreateDeploymentForFilter(t *testing.T) {
+	url := path.Join(deviceDeploymentsV2BasePath, "deployments")
+	c := restartHttpMock("POST", url, "", 201)
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		var body map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&body)
+
+		if body["name"] != "release" || body["artifact_name"] != "release-1" {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+
+		id := "all"
+		if body["all_devices"] != true {
+			id = body["filter_id"].(string)
+		}
+
+		return createdResponder(path.Join(deviceDeploymentsV2BasePath, "deployments", id))(req)
+	})
+
+	req := DeploymentRequest{Name: "release", ArtifactName: "release-1", Devices: []string{"ignored"}}
+
+	id, e := c.CreateDeploymentForFilter("rpi4", req)
+	if e != nil || id != "rpi4" {
+		t.Error(e, id)
+	}
+
+	id, e = c.CreateDeploymentForAllDevices(req)
+	if e != nil || id != "all" {
+		t.Error(e, id)
+	}
+
+	// no devices match the filter
+	c = restartHttpMock("POST", url, `{"error": "no devices"}`, 409)
+	_, e = c.CreateDeploymentForFilter("rpi4", req)
+	if !errors.Is(e, ErrNoDevicesMatch) {
+		t.Error(e)
+	}
+}
+
+func TestListDeployments(t *testing.T) {
+	url := path.Join(deviceDeploymentsBasePath, "deployments")
+	c := restartHttpMock("GET", url, `[{"id": "dep1", "status": "finished"}]`, 200)
+
+	l, e := c.ListDeployments(nil)
+	if e != nil || len(l) != 1 || l[0].ID != "dep1" {
+		t.Error(e, l)
+	}
+
+	// query parameters
+	httpmock.RegisterResponderWithQuery("GET", url,
+		"status=inprogress&search=release&created_before=1600000000&created_after=1500000000&sort=desc&page=1&per_page=20",
+		httpmock.NewStringResponder(200, `[{"id": "dep2", "status": "inprogress"}]`))
+
+	l, e = c.ListDeployments(&ListDeploymentsOptions{
+		Status:        "inprogress",
+		Search:        "release",
+		CreatedBefore: time.Unix(1600000000, 0),
+		CreatedAfter:  time.Unix(1500000000, 0),
+		Sort:          "desc",
+		Page:          1,
+		PerPage:       20,
+	})
+	if e != nil || len(l) != 1 || l[0].ID != "dep2" {
+		t.Error(e, l)
+	}
+
+	// error response
+	c = restartHttpMock("GET", url, `{}`, 400)
+	if _, e = c.ListDeployments(nil); e == nil {
+		t.Error(e)
+	}
+}
+
+func TestGetDeviceDeploymentHistory(t *testing.T) {
+	deviceId := "1"
+	url := path.Join(deviceDeploymentsBasePath, "deployments/devices", deviceId)
+	c := restartHttpMock("GET", url, "", 200)
+	httpmock.RegisterResponderWithQuery("GET", url, "status=failure&page=2&per_page=10",
+		httpmock.NewStringResponder(200, `[
+		{
+		  "id": "dd1",
+		  "deployment": {"id": "dep1", "name": "release", "artifact_name": "release-1", "status": "finished", "created": "2019-08-24T14:15:22Z"},
+		  "device": {"id": "1", "status": "failure", "substate": "download failed", "created": "2019-08-24T14:15:22Z", "finished": "2019-08-24T15:15:22Z"}
+		}
+	  ]`))
+
+	h, e := c.GetDeviceDeploymentHistory(deviceId, &DeviceDeploymentHistoryOptions{Status: "failure", Page: 2, PerPage: 10})
+	if e != nil || len(h) != 1 {
+		t.Fatal(e, h)
+	}
+
+	if h[0].Deployment.ArtifactName != "release-1" || h[0].Device.Substate != "download failed" ||
+		h[0].Device.Finished.Sub(h[0].Device.Created) != time.Hour {
+		t.Error(h)
+	}
+
+	// device not exists
+	c = restartHttpMock("GET", url, `{}`, 404)
+	if _, e = c.GetDeviceDeploymentHistory(deviceId, nil); e == nil {
+		t.Error(e)
+	}
+}
@@ -0,0 +1,382 @@
+package mender_rest_api_client
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const defaultArtifactFilename = "artifact.mender"
+
+var ErrArtifactExists = errors.New("Artifact already exists")
+
+var ErrArtifactChanged = errors.New("Artifact changed after signature verification")
+
+type UploadProgress struct {
+	Sent  int64
+	Total int64
+}
+
+type ArtifactUpload struct {
+	Reader io.Reader
+	// exact number of bytes in Reader
+	Size int64
+	// defaults to "artifact.mender"
+	Filename    string
+	Description string
+	// optional progress callback, called from uploading goroutine
+	Progress func(UploadProgress)
+	// optional progress channel, upload waits until each progress is received
+	ProgressChan chan<- UploadProgress
+	// optional key, unsigned or wrongly signed artifact is not uploaded.
+	// Reader must be io.ReadSeeker, it is read once for verification and
+	// upload fails with ErrArtifactChanged when second read differs
+	VerifyKey crypto.PublicKey
+}
+
+// presigned link for direct artifact upload
+type directUploadLink struct {
+	ID     string    `json:"id"`
+	URI    string    `json:"uri"`
+	Expire time.Time `json:"expire"`
+	// headers required by storage, e.g. "x-ms-blob-type" of Azure
+	Header map[string]string `json:"header"`
+}
+
+// Single file update artifact generated by server
+type GenerateArtifactRequest struct {
+	Name                  string
+	Description           string
+	DeviceTypesCompatible []string
+	// update module type, defaults to "single_file"
+	Type string
+	// destination directory and file name on device
+	DestDir  string
+	Filename string
+	// file content, Size must be exact number of bytes in Reader
+	Reader io.Reader
+	Size   int64
+	// optional progress callback of file upload
+	Progress func(UploadProgress)
+}
+
+type multipartField struct {
+	name  string
+	value string
+}
+
+// reader reporting number of bytes read so far
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	progress func(sent int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.sent)
+	}
+
+	return n, err
+}
+
+// Upload mender artifact streamed from reader, returns id of the new artifact
+func (c *Client) UploadArtifactStream(ctx context.Context, upload ArtifactUpload) (string, error) {
+	if upload.Reader == nil {
+		return "", fmt.Errorf("Artifact reader is missing")
+	}
+	if err := upload.verifySignature(); err != nil {
+		return "", err
+	}
+
+	filename := upload.Filename
+	if filename == "" {
+		filename = defaultArtifactFilename
+	}
+
+	fields := []multipartField{
+		{name: "size", value: strconv.FormatInt(upload.Size, 10)},
+		{name: "description", value: upload.Description},
+	}
+
+	resp, err := c.postMultipartStream(ctx, path.Join(deviceDeploymentsBasePath, "artifacts"),
+		fields, "artifact", filename, upload.Reader, upload.Size, upload.progressFunc(ctx))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return "", classifyError(err, map[int]error{
+			http.StatusConflict: ErrArtifactExists,
+		})
+	}
+
+	return idFromLocation(resp)
+}
+
+// Upload mender artifact directly to storage through presigned link, falls back
+// to UploadArtifactStream when server doesn't support direct upload.
+// Returns id of the new artifact
+func (c *Client) UploadArtifactDirect(ctx context.Context, upload ArtifactUpload) (string, error) {
+	if upload.Reader == nil {
+		return "", fmt.Errorf("Artifact reader is missing")
+	}
+	if err := upload.verifySignature(); err != nil {
+		return "", err
+	}
+
+	var link directUploadLink = directUploadLink{}
+
+	resp, err := c.client.R().SetContext(ctx).Post(path.Join(deviceDeploymentsBasePath, "artifacts/directupload"))
+	if err = checkAndReturnError(resp, err); err != nil {
+		if respErr, ok := err.(*ResponseError); ok {
+			switch respErr.StatusCode {
+			case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+				// signature is already verified
+				upload.VerifyKey = nil
+				return c.UploadArtifactStream(ctx, upload)
+			}
+		}
+		return "", err
+	}
+
+	if err = json.Unmarshal(resp.Body(), &link); err != nil {
+		return "", err
+	}
+
+	if !link.Expire.IsZero() && !time.Now().Before(link.Expire) {
+		return "", fmt.Errorf("Upload link expired at %v", link.Expire)
+	}
+
+	if err = c.putToStorage(ctx, link, upload.Reader, upload.Size, upload.progressFunc(ctx)); err != nil {
+		return "", err
+	}
+
+	resp, err = c.client.R().SetContext(ctx).Post(path.Join(deviceDeploymentsBasePath, "artifacts/directupload", link.ID, "complete"))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return "", classifyError(err, map[int]error{
+			http.StatusConflict: ErrArtifactExists,
+		})
+	}
+
+	return link.ID, nil
+}
+
+// Put file to presigned storage link with headers of the link, client
+// credentials are not sent to storage
+func (c *Client) putToStorage(ctx context.Context, link directUploadLink, r io.Reader, size int64, progress func(sent int64)) error {
+	if progress != nil {
+		r = &progressReader{r: r, progress: progress}
+	}
+
+	req, err := http.NewRequest(http.MethodPut, link.URI, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/vnd.mender-artifact")
+	for name, value := range link.Header {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.client.GetClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Storage error response:%v", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Generate single file update artifact on server, returns id of the new artifact
+func (c *Client) GenerateArtifact(ctx context.Context, req GenerateArtifactRequest) (string, error) {
+	if req.Reader == nil {
+		return "", fmt.Errorf("File reader is missing")
+	}
+	if req.Name == "" || len(req.DeviceTypesCompatible) == 0 {
+		return "", fmt.Errorf("Artifact name and device types are required")
+	}
+	if req.DestDir == "" || req.Filename == "" {
+		return "", fmt.Errorf("Destination directory and file name are required")
+	}
+
+	updateType := req.Type
+	if updateType == "" {
+		updateType = "single_file"
+	}
+
+	args, err := json.Marshal(map[string]string{
+		"filename": req.Filename,
+		"dest_dir": req.DestDir,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	fields := []multipartField{
+		{name: "name", value: req.Name},
+		{name: "description", value: req.Description},
+		{name: "type", value: updateType},
+		{name: "args", value: string(args)},
+	}
+	for _, deviceType := range req.DeviceTypesCompatible {
+		fields = append(fields, multipartField{name: "device_types_compatible", value: deviceType})
+	}
+
+	progress := ArtifactUpload{Size: req.Size, Progress: req.Progress}.progressFunc(ctx)
+
+	resp, err := c.postMultipartStream(ctx, path.Join(deviceDeploymentsBasePath, "artifacts/generate"),
+		fields, "file", req.Filename, req.Reader, req.Size, progress)
+	if err = checkAndReturnError(resp, err); err != nil {
+		return "", classifyError(err, map[int]error{
+			http.StatusConflict: ErrArtifactExists,
+		})
+	}
+
+	return idFromLocation(resp)
+}
+
+// Verify artifact signature when VerifyKey is set, reader is rewound and
+// replaced by reader checking that uploaded content is the verified one
+func (u *ArtifactUpload) verifySignature() error {
+	if u.VerifyKey == nil {
+		return nil
+	}
+
+	rs, ok := u.Reader.(io.ReadSeeker)
+	if !ok {
+		return fmt.Errorf("Artifact reader must be seekable for signature verification")
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	r := io.TeeReader(io.LimitReader(rs, u.Size), h)
+	if _, err = VerifyArtifact(r, u.VerifyKey); err != nil {
+		return err
+	}
+	// tar padding after last entry is not read by verification
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+
+	if _, err = rs.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	u.Reader = &verifiedReader{r: io.LimitReader(rs, u.Size), remaining: u.Size, h: sha256.New(), sum: h.Sum(nil)}
+
+	return nil
+}
+
+// reader failing with ErrArtifactChanged when content read differs from
+// verified content
+type verifiedReader struct {
+	r         io.Reader
+	remaining int64
+	h         hash.Hash
+	sum       []byte
+}
+
+func (v *verifiedReader) Read(b []byte) (int, error) {
+	n, err := v.r.Read(b)
+	v.h.Write(b[:n])
+	v.remaining -= int64(n)
+
+	if n > 0 && v.remaining == 0 && !bytes.Equal(v.h.Sum(nil), v.sum) {
+		return n, ErrArtifactChanged
+	}
+
+	return n, err
+}
+
+// Merge progress callback and channel, returns nil when no progress is requested
+func (u ArtifactUpload) progressFunc(ctx context.Context) func(int64) {
+	if u.Progress == nil && u.ProgressChan == nil {
+		return nil
+	}
+
+	return func(sent int64) {
+		p := UploadProgress{Sent: sent, Total: u.Size}
+		if u.Progress != nil {
+			u.Progress(p)
+		}
+		if u.ProgressChan != nil {
+			select {
+			case u.ProgressChan <- p:
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// Post multipart form with fields followed by file streamed from reader,
+// the body is never held in memory
+func (c *Client) postMultipartStream(ctx context.Context, url string, fields []multipartField,
+	fileField, filename string, r io.Reader, size int64, progress func(sent int64)) (*resty.Response, error) {
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	if progress != nil {
+		r = &progressReader{r: r, progress: progress}
+	}
+
+	go func() {
+		pw.CloseWithError(writeMultipart(mw, fields, fileField, filename, r, size))
+	}()
+
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", mw.FormDataContentType()).
+		SetBody(pr).
+		Post(url)
+
+	// stop writer if server responded before reading whole body
+	pr.Close()
+
+	return resp, err
+}
+
+func writeMultipart(mw *multipart.Writer, fields []multipartField, fileField, filename string, r io.Reader, size int64) error {
+	for _, f := range fields {
+		if err := mw.WriteField(f.name, f.value); err != nil {
+			return err
+		}
+	}
+
+	part, err := mw.CreateFormFile(fileField, filename)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(part, r)
+	if err != nil {
+		return err
+	}
+
+	if n != size {
+		return fmt.Errorf("Read %v bytes, expected %v", n, size)
+	}
+
+	return mw.Close()
+}
@@ -0,0 +1,234 @@
+package mender_rest_api_client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+// responder checking uploaded multipart form
+func artifactUploadResponder(t *testing.T, content []byte) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+
+		f, h, err := req.FormFile("artifact")
+		if err != nil {
+			t.Error(err)
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		data, _ := ioutil.ReadAll(f)
+
+		if !bytes.Equal(data, content) || req.FormValue("size") != "10000" || req.FormValue("description") != "desc" ||
+			h.Filename != "release.mender" {
+			t.Errorf("Invalid upload %v %v %v", len(data), req.FormValue("size"), h.Filename)
+		}
+
+		return createdResponder(path.Join(deviceDeploymentsBasePath, "artifacts", "art1"))(req)
+	}
+}
+
+func TestUploadArtifactStream(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 10000)
+	c := restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "artifacts"), "", 201)
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "artifacts"), artifactUploadResponder(t, content))
+
+	var last UploadProgress
+	progress := make(chan UploadProgress)
+	done := make(chan struct{})
+	go func() {
+		for p := range progress {
+			last = p
+		}
+		close(done)
+	}()
+
+	callbacks := 0
+	id, e := c.UploadArtifactStream(context.Background(), ArtifactUpload{
+		Reader:       bytes.NewReader(content),
+		Size:         int64(len(content)),
+		Filename:     "release.mender",
+		Description:  "desc",
+		Progress:     func(UploadProgress) { callbacks++ },
+		ProgressChan: progress,
+	})
+	close(progress)
+	<-done
+
+	if e != nil || id != "art1" {
+		t.Error(e, id)
+	}
+
+	if callbacks == 0 || last.Sent != 10000 || last.Total != 10000 {
+		t.Error(callbacks, last)
+	}
+
+	// wrong size
+	c = restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "artifacts"), "", 201)
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "artifacts"),
+		func(req *http.Request) (*http.Response, error) {
+			if _, err := ioutil.ReadAll(req.Body); err != nil {
+				return nil, err
+			}
+			return createdResponder(path.Join(deviceDeploymentsBasePath, "artifacts", "art1"))(req)
+		})
+	_, e = c.UploadArtifactStream(context.Background(), ArtifactUpload{Reader: bytes.NewReader(content), Size: 20000})
+	if e == nil {
+		t.Error(e)
+	}
+
+	// duplicate artifact
+	c = restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "artifacts"), `{"error": "artifact not unique"}`, 409)
+	_, e = c.UploadArtifactStream(context.Background(), ArtifactUpload{Reader: bytes.NewReader(content), Size: 10000})
+	if !errors.Is(e, ErrArtifactExists) {
+		t.Error(e)
+	}
+}
+
+func TestUploadArtifacts(t *testing.T) {
+	content := bytes.Repeat([]byte("b"), 10000)
+	c := restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "artifacts"), "", 201)
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "artifacts"), artifactUploadResponder(t, content))
+
+	dir, e := ioutil.TempDir("", "upload")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "release.mender")
+	ioutil.WriteFile(file, content, 0644)
+
+	if e = c.UploadArtifacts(file, "desc"); e != nil {
+		t.Error(e)
+	}
+
+	if e = c.UploadArtifacts(filepath.Join(dir, "missing.mender"), "desc"); e == nil {
+		t.Error(e)
+	}
+}
+
+func TestUploadArtifactDirect(t *testing.T) {
+	content := bytes.Repeat([]byte("c"), 10000)
+
+	// local stand-in for object storage
+	var stored []byte
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" || req.Header.Get("Authorization") != "" || req.ContentLength != 10000 ||
+			req.Header.Get("X-Ms-Blob-Type") != "BlockBlob" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		stored, _ = ioutil.ReadAll(req.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer storage.Close()
+
+	c := restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "artifacts/directupload"),
+		`{"id": "art2", "uri": "`+storage.URL+`/bucket/art2", "expire": "2030-01-01T00:00:00Z",
+		"header": {"x-ms-blob-type": "BlockBlob"}}`, 200)
+	c.client.SetHeader("Authorization", "Bearer token")
+	completed := false
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "artifacts/directupload", "art2", "complete"),
+		func(req *http.Request) (*http.Response, error) {
+			completed = true
+			return httpmock.NewStringResponse(202, ""), nil
+		})
+	httpmock.RegisterNoResponder(httpmock.InitialTransport.RoundTrip)
+
+	var sent int64
+	id, e := c.UploadArtifactDirect(context.Background(), ArtifactUpload{
+		Reader:   bytes.NewReader(content),
+		Size:     int64(len(content)),
+		Progress: func(p UploadProgress) { sent = p.Sent },
+	})
+	if e != nil || id != "art2" || !completed || !bytes.Equal(stored, content) || sent != 10000 {
+		t.Error(e, id, completed, len(stored), sent)
+	}
+
+	// cancelled upload
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, e = c.UploadArtifactDirect(ctx, ArtifactUpload{Reader: bytes.NewReader(content), Size: 10000}); e == nil {
+		t.Error(e)
+	}
+
+	// expired link
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "artifacts/directupload"),
+		httpmock.NewStringResponder(200, `{"id": "art2", "uri": "`+storage.URL+`/bucket/art2", "expire": "2020-01-01T00:00:00Z"}`))
+	stored = nil
+	if _, e = c.UploadArtifactDirect(context.Background(), ArtifactUpload{Reader: bytes.NewReader(content), Size: 10000}); e == nil || stored != nil {
+		t.Error(e)
+	}
+
+	// fallback to multipart upload
+	c = restartHttpMock("POST", path.Join(deviceDeploymentsBasePath, "artifacts/directupload"), `{}`, 404)
+	httpmock.RegisterResponder("POST", path.Join(deviceDeploymentsBasePath, "artifacts"),
+		createdResponder(path.Join(deviceDeploymentsBasePath, "artifacts", "art3")))
+
+	id, e = c.UploadArtifactDirect(context.Background(), ArtifactUpload{Reader: bytes.NewReader(content), Size: 10000})
+	if e != nil || id != "art3" {
+		t.Error(e, id)
+	}
+}
+
+func TestGenerateArtifact(t *testing.T) {
+	content := []byte("key=value\n")
+	url := path.Join(deviceDeploymentsBasePath, "artifacts/generate")
+	c := restartHttpMock("POST", url, "", 201)
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+
+		f, h, err := req.FormFile("file")
+		if err != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		data, _ := ioutil.ReadAll(f)
+
+		form := req.MultipartForm.Value
+		if !bytes.Equal(data, content) || h.Filename != "app.conf" || form["name"][0] != "config-1" ||
+			form["type"][0] != "single_file" || form["args"][0] != `{"dest_dir":"/etc/app","filename":"app.conf"}` ||
+			len(form["device_types_compatible"]) != 2 {
+			t.Errorf("Invalid request %v", form)
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+
+		return createdResponder(path.Join(deviceDeploymentsBasePath, "artifacts", "gen1"))(req)
+	})
+
+	id, e := c.GenerateArtifact(context.Background(), GenerateArtifactRequest{
+		Name:                  "config-1",
+		DeviceTypesCompatible: []string{"raspberrypi3", "raspberrypi4"},
+		DestDir:               "/etc/app",
+		Filename:              "app.conf",
+		Reader:                bytes.NewReader(content),
+		Size:                  int64(len(content)),
+	})
+	if e != nil || id != "gen1" {
+		t.Error(e, id)
+	}
+
+	// missing destination
+	_, e = c.GenerateArtifact(context.Background(), GenerateArtifactRequest{
+		Name:                  "config-1",
+		DeviceTypesCompatible: []string{"raspberrypi4"},
+		Reader:                bytes.NewReader(content),
+		Size:                  int64(len(content)),
+	})
+	if e == nil {
+		t.Error(e)
+	}
+}
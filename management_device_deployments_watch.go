@@ -0,0 +1,214 @@
+package mender_rest_api_client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultWatchInterval = 10 * time.Second
+
+type DeploymentEventType int
+
+const (
+	// deployment status changed, e.g. from "pending" to "inprogress"
+	DeploymentStatusChanged DeploymentEventType = iota
+	// device counts per state changed
+	DeploymentStatisticsChanged
+	// status of single device changed
+	DeploymentDeviceChanged
+	// deployment was aborted because failure threshold was reached, when abort
+	// failed Err is set and abort is retried on next poll
+	DeploymentAutoAborted
+	// deployment finished, last event
+	DeploymentFinished
+	// polling failed, watching continues unless deployment can't be read
+	// because of client error, e.g. deployment not found
+	DeploymentWatchError
+)
+
+type DeploymentEvent struct {
+	Type       DeploymentEventType
+	Time       time.Time
+	Status     string
+	Statistics DeploymentStatistics
+	// set for DeploymentDeviceChanged
+	DeviceId             string
+	DeviceStatus         string
+	PreviousDeviceStatus string
+	// set for DeploymentWatchError and failed auto abort
+	Err error
+}
+
+type WatchOptions struct {
+	// polling interval, 10s when zero
+	Interval time.Duration
+	// report status changes of each device, whole device list is read on each poll
+	WatchDevices bool
+	// abort deployment when number of failed devices reaches value, zero disables
+	AbortOnFailures int
+	// abort deployment when ratio of failed devices reaches value, zero disables
+	AbortOnFailureRatio float64
+}
+
+type deploymentWatch struct {
+	c            *Client
+	deploymentId string
+	opts         WatchOptions
+	events       chan DeploymentEvent
+
+	status     string
+	statistics DeploymentStatistics
+	devices    map[string]string
+}
+
+// Watch deployment progress, events are sent until the deployment finishes
+// or the context is cancelled, then the channel is closed
+func (c *Client) WatchDeployment(ctx context.Context, deploymentId string, opts WatchOptions) <-chan DeploymentEvent {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultWatchInterval
+	}
+
+	w := &deploymentWatch{
+		c:            c,
+		deploymentId: deploymentId,
+		opts:         opts,
+		events:       make(chan DeploymentEvent),
+		devices:      map[string]string{},
+	}
+
+	go w.run(ctx)
+
+	return w.events
+}
+
+func (w *deploymentWatch) run(ctx context.Context) {
+	defer close(w.events)
+
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if done := w.poll(ctx); done {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Read deployment state and send events, returns true when watching is over
+func (w *deploymentWatch) poll(ctx context.Context) bool {
+	deployment, err := w.c.ShowDeployment(w.deploymentId)
+	if err != nil {
+		return !w.send(ctx, DeploymentEvent{Type: DeploymentWatchError, Err: err}) || isClientError(err)
+	}
+
+	statistics, err := w.c.DeploymentStatistics(w.deploymentId)
+	if err != nil {
+		return !w.send(ctx, DeploymentEvent{Type: DeploymentWatchError, Err: err})
+	}
+
+	// events carry state of whole poll
+	statusChanged := deployment.Status != w.status
+	statisticsChanged := statistics != w.statistics
+	w.status = deployment.Status
+	w.statistics = statistics
+
+	if statusChanged {
+		if !w.send(ctx, DeploymentEvent{Type: DeploymentStatusChanged}) {
+			return true
+		}
+	}
+
+	if statisticsChanged {
+		if !w.send(ctx, DeploymentEvent{Type: DeploymentStatisticsChanged}) {
+			return true
+		}
+	}
+
+	if w.opts.WatchDevices {
+		devices, err := w.c.ListDevicesInDeployment(w.deploymentId)
+		if err != nil {
+			return !w.send(ctx, DeploymentEvent{Type: DeploymentWatchError, Err: err})
+		}
+
+		for _, d := range devices {
+			previous := w.devices[d.ID]
+			if previous == d.Status {
+				continue
+			}
+			w.devices[d.ID] = d.Status
+
+			event := DeploymentEvent{
+				Type:                 DeploymentDeviceChanged,
+				DeviceId:             d.ID,
+				DeviceStatus:         d.Status,
+				PreviousDeviceStatus: previous,
+			}
+			if !w.send(ctx, event) {
+				return true
+			}
+		}
+	}
+
+	if deployment.Status == "finished" {
+		w.send(ctx, DeploymentEvent{Type: DeploymentFinished})
+		return true
+	}
+
+	if reason := w.abortReason(deployment.DeviceCount); reason != "" {
+		if err := w.c.AbortDeployment(w.deploymentId); err != nil {
+			err = fmt.Errorf("Failed to abort deployment (%v): %v", reason, err)
+			return !w.send(ctx, DeploymentEvent{Type: DeploymentAutoAborted, Err: err})
+		}
+		w.send(ctx, DeploymentEvent{Type: DeploymentAutoAborted})
+		return true
+	}
+
+	return false
+}
+
+// Check failure thresholds, returns empty string when deployment can continue
+func (w *deploymentWatch) abortReason(deviceCount int) string {
+	failures := w.statistics.Failure
+
+	if w.opts.AbortOnFailures > 0 && failures >= w.opts.AbortOnFailures {
+		return fmt.Sprintf("%v devices failed", failures)
+	}
+
+	if w.opts.AbortOnFailureRatio > 0 && deviceCount > 0 {
+		ratio := float64(failures) / float64(deviceCount)
+		if ratio >= w.opts.AbortOnFailureRatio {
+			return fmt.Sprintf("%.0f%% devices failed", ratio*100)
+		}
+	}
+
+	return ""
+}
+
+// Check if error is 4xx response which won't succeed on retry
+func isClientError(err error) bool {
+	respErr, ok := err.(*ResponseError)
+	return ok && respErr.StatusCode >= 400 && respErr.StatusCode < 500 &&
+		respErr.StatusCode != http.StatusTooManyRequests
+}
+
+// Send event with current state, returns false when context is cancelled
+func (w *deploymentWatch) send(ctx context.Context, event DeploymentEvent) bool {
+	event.Time = time.Now()
+	event.Status = w.status
+	event.Statistics = w.statistics
+
+	select {
+	case w.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
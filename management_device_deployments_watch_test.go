@@ -0,0 +1,139 @@
+package mender_rest_api_client
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+// register responders returning next response on each call, last one is repeated
+func sequenceResponder(method, url string, responses ...string) {
+	calls := 0
+	httpmock.RegisterResponder(method, url, func(req *http.Request) (*http.Response, error) {
+		r := responses[calls]
+		if calls < len(responses)-1 {
+			calls++
+		}
+		return httpmock.NewStringResponse(200, r), nil
+	})
+}
+
+func TestWatchDeployment(t *testing.T) {
+	deploymentId := "dep1"
+	c := restartHttpMock("GET", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId), "", 200)
+	sequenceResponder("GET", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId),
+		`{"status": "pending", "device_count": 2}`,
+		`{"status": "inprogress", "device_count": 2}`,
+		`{"status": "finished", "device_count": 2}`)
+	sequenceResponder("GET", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "statistics"),
+		`{"pending": 2}`,
+		`{"pending": 1, "downloading": 1}`,
+		`{"success": 2}`)
+	sequenceResponder("GET", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "devices"),
+		`[{"id": "1", "status": "pending"}, {"id": "2", "status": "pending"}]`,
+		`[{"id": "1", "status": "downloading"}, {"id": "2", "status": "pending"}]`,
+		`[{"id": "1", "status": "success"}, {"id": "2", "status": "success"}]`)
+
+	events := c.WatchDeployment(context.Background(), deploymentId, WatchOptions{Interval: time.Millisecond, WatchDevices: true})
+
+	var received []DeploymentEvent
+	for e := range events {
+		received = append(received, e)
+	}
+
+	types := []DeploymentEventType{
+		DeploymentStatusChanged, DeploymentStatisticsChanged, DeploymentDeviceChanged, DeploymentDeviceChanged,
+		DeploymentStatusChanged, DeploymentStatisticsChanged, DeploymentDeviceChanged,
+		DeploymentStatusChanged, DeploymentStatisticsChanged, DeploymentDeviceChanged, DeploymentDeviceChanged,
+		DeploymentFinished,
+	}
+	if len(received) != len(types) {
+		t.Fatal(received)
+	}
+	for i, e := range received {
+		if e.Type != types[i] {
+			t.Errorf("Invalid event %v: %v", i, e)
+		}
+	}
+
+	if received[6].DeviceId != "1" || received[6].PreviousDeviceStatus != "pending" || received[6].DeviceStatus != "downloading" {
+		t.Error(received[6])
+	}
+
+	// status event carries statistics of the same poll
+	if received[4].Status != "inprogress" || received[4].Statistics.Downloading != 1 {
+		t.Error(received[4])
+	}
+
+	if last := received[len(received)-1]; last.Status != "finished" || last.Statistics.Success != 2 {
+		t.Error(last)
+	}
+}
+
+func TestWatchDeploymentAutoAbort(t *testing.T) {
+	deploymentId := "dep1"
+	c := restartHttpMock("GET", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId), `{"status": "inprogress", "device_count": 10}`, 200)
+	sequenceResponder("GET", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "statistics"),
+		`{"failure": 1, "downloading": 9}`,
+		`{"failure": 3, "downloading": 7}`)
+
+	// first abort fails and is retried
+	aborts := 0
+	httpmock.RegisterResponder("PUT", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId, "status"),
+		func(req *http.Request) (*http.Response, error) {
+			aborts++
+			if aborts == 1 {
+				return httpmock.NewStringResponse(500, ""), nil
+			}
+			return httpmock.NewStringResponse(204, ""), nil
+		})
+
+	events := c.WatchDeployment(context.Background(), deploymentId, WatchOptions{Interval: time.Millisecond, AbortOnFailureRatio: 0.25})
+
+	var received []DeploymentEvent
+	for e := range events {
+		if e.Type == DeploymentAutoAborted {
+			received = append(received, e)
+		}
+	}
+
+	if aborts != 2 || len(received) != 2 || received[0].Err == nil || received[1].Err != nil ||
+		received[1].Statistics.Failure != 3 {
+		t.Error(aborts, received)
+	}
+}
+
+func TestWatchDeploymentNotFound(t *testing.T) {
+	deploymentId := "dep1"
+	c := restartHttpMock("GET", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId), `{"error": "not found"}`, 404)
+
+	var received []DeploymentEvent
+	for e := range c.WatchDeployment(context.Background(), deploymentId, WatchOptions{Interval: time.Millisecond}) {
+		received = append(received, e)
+	}
+
+	if len(received) != 1 || received[0].Type != DeploymentWatchError || received[0].Err == nil {
+		t.Error(received)
+	}
+}
+
+func TestWatchDeploymentCancel(t *testing.T) {
+	deploymentId := "dep1"
+	c := restartHttpMock("GET", path.Join(deviceDeploymentsBasePath, "deployments", deploymentId), `{}`, 500)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := c.WatchDeployment(ctx, deploymentId, WatchOptions{Interval: time.Millisecond})
+
+	e := <-events
+	if e.Type != DeploymentWatchError || e.Err == nil {
+		t.Error(e)
+	}
+
+	cancel()
+	for range events {
+	}
+}
@@ -1,53 +1,386 @@
 package mender_rest_api_client
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"path"
+	"strconv"
+	"sync"
 	"time"
 )
 
 const deviceInventoryBasePath = "/api/management/v1/inventory"
 
+// bulk group membership changes are split into chunks sent concurrently
+const (
+	groupUpdateChunkSize = 1000
+	groupUpdateWorkers   = 4
+)
+
+// Inventory attribute value, it can be string, number, bool or array of strings.
+// Raw json is kept so value is encoded back without any loss.
+type AttributeValue struct {
+	raw json.RawMessage
+}
+
 type DeviceGroup struct {
 	Group string `json:"group"`
 }
 
-type DeviceInventoryList []struct {
-	ID         string `json:"id"`
-	Attributes []struct {
-		Name        string `json:"name"`
-		Scope       string `json:"scope"`
-		Value       string `json:"value"`
-		Description string `json:"description"`
-	} `json:"attributes"`
-	UpdatedTs time.Time `json:"updated_ts"`
+type InventoryAttribute struct {
+	Name        string         `json:"name"`
+	Scope       string         `json:"scope"`
+	Value       AttributeValue `json:"value"`
+	Description string         `json:"description"`
 }
 
-type DeviceInventory struct {
-	ID         string `json:"id"`
-	Attributes []struct {
-		Name        string `json:"name"`
-		Scope       string `json:"scope"`
-		Value       string `json:"value"`
-		Description string `json:"description"`
-	} `json:"attributes"`
-	UpdatedTs time.Time `json:"updated_ts"`
+type InventoryDevice struct {
+	ID         string               `json:"id"`
+	Attributes []InventoryAttribute `json:"attributes"`
+	UpdatedTs  time.Time            `json:"updated_ts"`
 }
 
+type DeviceInventoryList []InventoryDevice
+
+type DeviceInventory = InventoryDevice
+
+// well known attribute scopes
+const (
+	ScopeIdentity  = "identity"
+	ScopeInventory = "inventory"
+	ScopeSystem    = "system"
+	ScopeTags      = "tags"
+)
+
 type DeviceGroupData struct {
 	Group string `json:"group"`
 }
 
-// List devices inventories
-// TODO: support for queries
-func (c *Client) ListDeviceInventories() (DeviceInventoryList, error) {
+type DeviceTag struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+// Called after each chunk of devices processed by group workflow
+type GroupProgressFunc func(done, total int)
+
+// Result of group workflow, failed devices stay in their original group
+type GroupMoveResult struct {
+	Moved  []string
+	Failed map[string]error
+}
+
+// Result of bulk group membership change
+type GroupUpdateResult struct {
+	UpdatedCount int               `json:"updated_count"`
+	MatchedCount int               `json:"matched_count"`
+	Errors       []GroupChunkError `json:"-"`
+}
+
+// Failed chunk of bulk group membership change
+type GroupChunkError struct {
+	Devices []string
+	Err     error
+}
+
+func (e GroupChunkError) Error() string {
+	return fmt.Sprintf("Failed to update %v devices: %v", len(e.Devices), e.Err)
+}
+
+// Device was modified since etag was obtained
+type PreconditionFailedError struct {
+	DeviceId string
+	ETag     string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("Device %v was modified, etag %v doesn't match", e.DeviceId, e.ETag)
+}
+
+// Key of attribute in map returned by InventoryDevice.ToMap
+func AttributeKey(scope, name string) string {
+	return scope + "/" + name
+}
+
+// Find attribute by scope and name. Devices carry only a few dozen
+// attributes, so a linear scan is used instead of an index that would
+// have to be kept in sync with changes of Attributes; use ToMap for
+// repeated lookups.
+func (d *InventoryDevice) Attribute(scope, name string) (InventoryAttribute, bool) {
+	for _, a := range d.Attributes {
+		if a.Scope == scope && a.Name == name {
+			return a, true
+		}
+	}
+
+	return InventoryAttribute{}, false
+}
+
+// Get attribute value formatted as string, empty if attribute is missing
+func (d *InventoryDevice) AttributeString(scope, name string) string {
+	a, ok := d.Attribute(scope, name)
+	if !ok {
+		return ""
+	}
+
+	return a.Value.String()
+}
+
+func (d *InventoryDevice) DeviceType() string {
+	return d.AttributeString(ScopeInventory, "device_type")
+}
+
+func (d *InventoryDevice) ArtifactName() string {
+	return d.AttributeString(ScopeInventory, "artifact_name")
+}
+
+func (d *InventoryDevice) Hostname() string {
+	return d.AttributeString(ScopeInventory, "hostname")
+}
+
+func (d *InventoryDevice) Kernel() string {
+	return d.AttributeString(ScopeInventory, "kernel")
+}
+
+func (d *InventoryDevice) Group() string {
+	return d.AttributeString(ScopeSystem, "group")
+}
+
+// Convert attributes to map with keys created by AttributeKey
+func (d *InventoryDevice) ToMap() map[string]AttributeValue {
+	m := make(map[string]AttributeValue, len(d.Attributes))
+	for _, a := range d.Attributes {
+		m[AttributeKey(a.Scope, a.Name)] = a.Value
+	}
+
+	return m
+}
+
+// Create attribute value from string, number, bool or slice of strings
+func NewAttributeValue(v interface{}) (AttributeValue, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return AttributeValue{}, err
+	}
+
+	return AttributeValue{raw: raw}, nil
+}
+
+func (v *AttributeValue) UnmarshalJSON(data []byte) error {
+	v.raw = append(json.RawMessage{}, data...)
+	return nil
+}
+
+func (v AttributeValue) MarshalJSON() ([]byte, error) {
+	if len(v.raw) == 0 {
+		return []byte("null"), nil
+	}
+
+	return v.raw, nil
+}
+
+// Check if value is missing or json null
+func (v AttributeValue) isNull() bool {
+	raw := bytes.TrimSpace(v.raw)
+	return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
+}
+
+// Check if value is json array
+func (v AttributeValue) IsArray() bool {
+	return bytes.HasPrefix(bytes.TrimSpace(v.raw), []byte("["))
+}
+
+// Decode raw json, numbers are decoded as json.Number to keep their precision
+func (v AttributeValue) decode() (interface{}, error) {
+	var value interface{}
+	d := json.NewDecoder(bytes.NewReader(v.raw))
+	d.UseNumber()
+	if err := d.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Get value as string, numbers and bools are formatted
+func (v AttributeValue) AsString() (string, error) {
+	value, err := v.decode()
+	if err != nil {
+		return "", err
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+
+	return "", fmt.Errorf("Value %s is not a string", v.raw)
+}
+
+// Get value as integer, numeric strings are parsed
+func (v AttributeValue) AsInt() (int64, error) {
+	value, err := v.decode()
+	if err != nil {
+		return 0, err
+	}
+
+	switch value := value.(type) {
+	case json.Number:
+		i, err := value.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("Value %s is not an integer", v.raw)
+		}
+		return i, nil
+	case string:
+		return strconv.ParseInt(value, 10, 64)
+	}
+
+	return 0, fmt.Errorf("Value %s is not an integer", v.raw)
+}
+
+// Get value as bool, "true" and "false" strings are parsed
+func (v AttributeValue) AsBool() (bool, error) {
+	var value interface{}
+	if err := json.Unmarshal(v.raw, &value); err != nil {
+		return false, err
+	}
+
+	switch value := value.(type) {
+	case bool:
+		return value, nil
+	case string:
+		return strconv.ParseBool(value)
+	}
+
+	return false, fmt.Errorf("Value %s is not a bool", v.raw)
+}
+
+// Get value as slice of strings, single value is returned as one element slice
+func (v AttributeValue) AsStrings() ([]string, error) {
+	if !v.IsArray() {
+		s, err := v.AsString()
+		if err != nil {
+			return []string{}, err
+		}
+		return []string{s}, nil
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(v.raw, &values); err != nil {
+		return []string{}, err
+	}
+
+	strs := make([]string, 0, len(values))
+	for _, raw := range values {
+		s, err := AttributeValue{raw: raw}.AsString()
+		if err != nil {
+			return []string{}, err
+		}
+		strs = append(strs, s)
+	}
+
+	return strs, nil
+}
+
+// Compare values ignoring json formatting
+func (v AttributeValue) Equal(o AttributeValue) bool {
+	var a, b bytes.Buffer
+	if json.Compact(&a, v.raw) != nil || json.Compact(&b, o.raw) != nil {
+		return bytes.Equal(v.raw, o.raw)
+	}
+
+	return bytes.Equal(a.Bytes(), b.Bytes())
+}
+
+// Human readable value, arrays are printed as json, null is empty
+func (v AttributeValue) String() string {
+	if v.isNull() {
+		return ""
+	}
+
+	if s, err := v.AsString(); err == nil {
+		return s
+	}
+
+	return string(v.raw)
+}
+
+// Query parameters of device inventories listing, zero values are not sent
+type InventoryListOptions struct {
+	Page    int
+	PerPage int
+	// attribute name with order, e.g. "hostname:asc"
+	Sort     string
+	HasGroup *bool
+	Group    string
+	// attribute name to value equality filters, names of other query
+	// parameters are rejected
+	Attributes map[string]string
+}
+
+// query parameters not allowed as attribute filter names
+var inventoryReservedParams = map[string]bool{
+	"page":      true,
+	"per_page":  true,
+	"sort":      true,
+	"has_group": true,
+	"group":     true,
+}
+
+func (o *InventoryListOptions) queryParams() (url.Values, error) {
+	params := url.Values{}
+	if o == nil {
+		return params, nil
+	}
+
+	if o.Page > 0 {
+		params.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage > 0 {
+		params.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+	if o.Sort != "" {
+		params.Set("sort", o.Sort)
+	}
+	if o.HasGroup != nil {
+		params.Set("has_group", strconv.FormatBool(*o.HasGroup))
+	}
+	if o.Group != "" {
+		params.Set("group", o.Group)
+	}
+	for name, value := range o.Attributes {
+		if inventoryReservedParams[name] {
+			return params, fmt.Errorf("Attribute filter %v conflicts with reserved query parameter", name)
+		}
+		params.Set(name, value)
+	}
+
+	return params, nil
+}
+
+// List devices inventories, opts can be nil
+func (c *Client) ListDeviceInventories(opts *InventoryListOptions) (DeviceInventoryList, error) {
 	var devInventory DeviceInventoryList = DeviceInventoryList{}
-	resp, err := c.client.R().Get(path.Join(deviceInventoryBasePath, "devices"))
+	params, err := opts.queryParams()
 	if err != nil {
 		return devInventory, err
 	}
 
+	resp, err := c.client.R().
+		SetQueryParamsFromValues(params).
+		Get(path.Join(deviceInventoryBasePath, "devices"))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return devInventory, err
+	}
+
 	if err = json.Unmarshal(resp.Body(), &devInventory); err != nil {
 		return devInventory, err
 	}
@@ -55,6 +388,30 @@ func (c *Client) ListDeviceInventories() (DeviceInventoryList, error) {
 	return devInventory, nil
 }
 
+// Call fn with each page of device inventories, opts paging is overridden
+func (c *Client) forEachInventoryPage(opts *InventoryListOptions, fn func(DeviceInventoryList) error) error {
+	var pageOpts InventoryListOptions
+	if opts != nil {
+		pageOpts = *opts
+	}
+	pageOpts.PerPage = pageSize
+
+	for pageOpts.Page = 1; ; pageOpts.Page++ {
+		devices, err := c.ListDeviceInventories(&pageOpts)
+		if err != nil {
+			return err
+		}
+
+		if len(devices) == 0 {
+			return nil
+		}
+
+		if err = fn(devices); err != nil {
+			return err
+		}
+	}
+}
+
 // Get a selected device's inventory
 // TODO: test
 func (c *Client) GetDeviceInventory(deviceId string) (DeviceInventory, error) {
@@ -71,7 +428,7 @@ func (c *Client) GetDeviceInventory(deviceId string) (DeviceInventory, error) {
 	return devInventory, nil
 }
 
-//Remove selected device's inventory
+// Remove selected device's inventory
 // TODO: test
 func (c *Client) DeleteDeviceInventory(deviceId string) error {
 	_, err := c.client.R().Delete(path.Join(deviceInventoryBasePath, "devices", deviceId))
@@ -110,8 +467,8 @@ func (c *Client) AssignGroup(deviceId, groupName string) error {
 		return fmt.Errorf("Failed to marshall group %v", e)
 	}
 
-	_, err := c.client.R().SetBody(g).Put(path.Join(deviceInventoryBasePath, "devices", deviceId, "group"))
-	if err != nil {
+	resp, err := c.client.R().SetBody(g).Put(path.Join(deviceInventoryBasePath, "devices", deviceId, "group"))
+	if err = checkAndReturnError(resp, err); err != nil {
 		return err
 	}
 
@@ -145,52 +502,262 @@ func (c *Client) ListGroups() ([]string, error) {
 	return listGroups, nil
 }
 
-// List the devices belonging to a given group
-// TODO: test
+// List the devices belonging to a given group, all pages are fetched
 func (c *Client) GetDevicesInGroup(groupName string) ([]string, error) {
 	var listDevicesInGroup []string = []string{}
-	resp, err := c.client.R().Get(path.Join(deviceInventoryBasePath, "groups", groupName, "devices"))
-	if err != nil {
-		return listDevicesInGroup, err
-	}
 
-	if err = json.Unmarshal(resp.Body(), &listDevicesInGroup); err != nil {
-		return listDevicesInGroup, err
+	for page := 1; ; page++ {
+		var devices []string
+		resp, err := c.client.R().
+			SetQueryParam("page", strconv.Itoa(page)).
+			SetQueryParam("per_page", strconv.Itoa(pageSize)).
+			Get(path.Join(deviceInventoryBasePath, "groups", groupName, "devices"))
+		if err = checkAndReturnError(resp, err); err != nil {
+			return listDevicesInGroup, err
+		}
+
+		if err = json.Unmarshal(resp.Body(), &devices); err != nil {
+			return listDevicesInGroup, err
+		}
+
+		if len(devices) == 0 {
+			break
+		}
+
+		listDevicesInGroup = append(listDevicesInGroup, devices...)
 	}
 
 	return listDevicesInGroup, nil
 }
 
-// Add devices to group
-// TODO: test
-func (c *Client) AddDevicesToGroup(groupName string, devices []string) error {
+// Add devices to group, large lists are sent in concurrent chunks
+func (c *Client) AddDevicesToGroup(groupName string, devices []string) (GroupUpdateResult, error) {
+	return c.updateGroupDevices("PATCH", groupName, devices, nil)
+}
+
+// Clear devices' group, large lists are sent in concurrent chunks
+func (c *Client) RemoveDevicesFromGroup(groupName string, devices []string) (GroupUpdateResult, error) {
+	return c.updateGroupDevices("DELETE", groupName, devices, nil)
+}
+
+// Send chunks of group membership change concurrently, progress can be nil
+func (c *Client) updateGroupDevices(method, groupName string, devices []string, progress GroupProgressFunc) (GroupUpdateResult, error) {
+	var result GroupUpdateResult = GroupUpdateResult{Errors: []GroupChunkError{}}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	workers := make(chan struct{}, groupUpdateWorkers)
+	chunks := 0
+	done := 0
+
+	for start := 0; start < len(devices); start += groupUpdateChunkSize {
+		end := start + groupUpdateChunkSize
+		if end > len(devices) {
+			end = len(devices)
+		}
+		chunk := devices[start:end]
+		chunks++
+
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			counts, err := c.updateGroupDevicesChunk(method, groupName, chunk)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if progress != nil {
+				done += len(chunk)
+				progress(done, len(devices))
+			}
+			if err != nil {
+				result.Errors = append(result.Errors, GroupChunkError{Devices: chunk, Err: err})
+				return
+			}
+			result.UpdatedCount += counts.UpdatedCount
+			result.MatchedCount += counts.MatchedCount
+		}()
+	}
+
+	wg.Wait()
+
+	if len(result.Errors) > 0 {
+		return result, fmt.Errorf("Failed to update %v of %v chunks", len(result.Errors), chunks)
+	}
+
+	return result, nil
+}
+
+func (c *Client) updateGroupDevicesChunk(method, groupName string, devices []string) (GroupUpdateResult, error) {
+	var counts GroupUpdateResult = GroupUpdateResult{}
 
 	d, e := json.Marshal(devices)
 	if e != nil {
-		return fmt.Errorf("Failed to marshall group %v", e)
+		return counts, fmt.Errorf("Failed to marshall group %v", e)
 	}
 
-	_, err := c.client.R().SetBody(d).Patch(path.Join(deviceInventoryBasePath, "groups", groupName, "devices"))
-	if err != nil {
-		return err
+	resp, err := c.client.R().SetBody(d).Execute(method, path.Join(deviceInventoryBasePath, "groups", groupName, "devices"))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return counts, err
 	}
 
-	return nil
+	// counts are optional
+	if len(resp.Body()) > 0 {
+		if err = json.Unmarshal(resp.Body(), &counts); err != nil {
+			return counts, err
+		}
+	}
+
+	return counts, nil
 }
 
-// Clear devices' group
-// TODO: test
-func (c *Client) RemoveDevicesFromGroup(groupName string, devices []string) error {
+// Get tags of a device with etag of device's inventory
+func (c *Client) GetDeviceTags(deviceId string) ([]DeviceTag, string, error) {
+	var devInventory DeviceInventory = DeviceInventory{}
+	var tags []DeviceTag = []DeviceTag{}
 
-	d, e := json.Marshal(devices)
+	resp, err := c.client.R().Get(path.Join(deviceInventoryBasePath, "devices", deviceId))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return tags, "", err
+	}
+
+	if err = json.Unmarshal(resp.Body(), &devInventory); err != nil {
+		return tags, "", err
+	}
+
+	for _, a := range devInventory.Attributes {
+		if a.Scope != ScopeTags {
+			continue
+		}
+
+		value, err := a.Value.AsString()
+		if err != nil {
+			return tags, "", err
+		}
+
+		tags = append(tags, DeviceTag{Name: a.Name, Value: value, Description: a.Description})
+	}
+
+	return tags, resp.Header().Get("ETag"), nil
+}
+
+// Replace all tags of a device, empty etag skips concurrency check
+func (c *Client) SetDeviceTags(deviceId string, tags []DeviceTag, etag string) error {
+	return c.writeDeviceTags("PUT", deviceId, tags, etag)
+}
+
+// Add or update tags of a device, empty etag skips concurrency check
+func (c *Client) UpdateDeviceTags(deviceId string, tags []DeviceTag, etag string) error {
+	return c.writeDeviceTags("PATCH", deviceId, tags, etag)
+}
+
+// Add or update tags on multiple devices, returns errors per device id
+func (c *Client) TagDevices(deviceIds []string, tags []DeviceTag) map[string]error {
+	failed := map[string]error{}
+
+	for _, deviceId := range deviceIds {
+		if err := c.UpdateDeviceTags(deviceId, tags, ""); err != nil {
+			failed[deviceId] = err
+		}
+	}
+
+	return failed
+}
+
+func (c *Client) writeDeviceTags(method, deviceId string, tags []DeviceTag, etag string) error {
+	t, e := json.Marshal(tags)
 	if e != nil {
-		return fmt.Errorf("Failed to marshall group %v", e)
+		return fmt.Errorf("Failed to marshall tags %v", e)
 	}
 
-	_, err := c.client.R().SetBody(d).Delete(path.Join(deviceInventoryBasePath, "groups", groupName, "devices"))
-	if err != nil {
+	req := c.client.R().SetBody(t)
+	if etag != "" {
+		req.SetHeader("If-Match", etag)
+	}
+
+	resp, err := req.Execute(method, path.Join(deviceInventoryBasePath, "devices", deviceId, "tags"))
+	if err == nil && resp.StatusCode() == http.StatusPreconditionFailed {
+		return &PreconditionFailedError{DeviceId: deviceId, ETag: etag}
+	}
+
+	if err = checkAndReturnError(resp, err); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete a group, devices of the group become ungrouped
+func (c *Client) DeleteGroup(groupName string) error {
+	resp, err := c.client.R().Delete(path.Join(deviceInventoryBasePath, "groups", groupName))
+	if err = checkAndReturnError(resp, err); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Rename a group by moving all its devices to new group, new group must not exist.
+// progress can be nil
+func (c *Client) RenameGroup(groupName, newGroupName string, progress GroupProgressFunc) (GroupMoveResult, error) {
+	devices, err := c.groupMembers(newGroupName)
+	if err != nil {
+		return GroupMoveResult{Failed: map[string]error{}}, err
+	}
+
+	if len(devices) > 0 {
+		return GroupMoveResult{Failed: map[string]error{}}, fmt.Errorf("Group %v already exists", newGroupName)
+	}
+
+	return c.MergeGroups(newGroupName, []string{groupName}, progress)
+}
+
+// Move all devices from source groups to target group in bulk chunks.
+// progress can be nil
+func (c *Client) MergeGroups(groupName string, sourceGroups []string, progress GroupProgressFunc) (GroupMoveResult, error) {
+	result := GroupMoveResult{Moved: []string{}, Failed: map[string]error{}}
+
+	var devices []string
+	for _, source := range sourceGroups {
+		if source == groupName {
+			continue
+		}
+
+		d, err := c.groupMembers(source)
+		if err != nil {
+			return result, err
+		}
+		devices = append(devices, d...)
+	}
+
+	update, _ := c.updateGroupDevices("PATCH", groupName, devices, progress)
+	for _, chunkErr := range update.Errors {
+		for _, deviceId := range chunkErr.Devices {
+			result.Failed[deviceId] = chunkErr.Err
+		}
+	}
+
+	for _, deviceId := range devices {
+		if result.Failed[deviceId] == nil {
+			result.Moved = append(result.Moved, deviceId)
+		}
+	}
+
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("Failed to move %v of %v devices", len(result.Failed), len(devices))
+	}
+
+	return result, nil
+}
+
+// List devices in group, not existing group is empty
+func (c *Client) groupMembers(groupName string) ([]string, error) {
+	devices, err := c.GetDevicesInGroup(groupName)
+	if respErr, ok := err.(*ResponseError); ok && respErr.StatusCode == http.StatusNotFound {
+		return []string{}, nil
+	}
+
+	return devices, err
+}
@@ -0,0 +1,166 @@
+package mender_rest_api_client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type ExportFormat int
+
+const (
+	ExportCSV ExportFormat = iota
+	ExportNDJSON
+)
+
+// How attributes with array values are flattened
+type MultiValueMode int
+
+const (
+	// values joined with MultiValueSeparator
+	MultiValueJoin MultiValueMode = iota
+	// only first value
+	MultiValueFirst
+	// json encoded array
+	MultiValueJSON
+)
+
+const defaultMultiValueSeparator = ";"
+
+// Attribute exported as column
+type ExportColumn struct {
+	Scope string
+	Name  string
+	// column name, AttributeKey is used when empty
+	Header string
+}
+
+type ExportOptions struct {
+	Format ExportFormat
+	// required for CSV, NDJSON exports all attributes when empty
+	Columns             []ExportColumn
+	MultiValue          MultiValueMode
+	MultiValueSeparator string
+	// optional filter of exported devices, paging is ignored
+	Filter *InventoryListOptions
+}
+
+// Stream inventory of all devices to writer page by page, returns number of exported devices
+func (c *Client) ExportInventory(w io.Writer, opts ExportOptions) (int, error) {
+	if opts.Format == ExportCSV && len(opts.Columns) == 0 {
+		return 0, fmt.Errorf("Columns are required for CSV export")
+	}
+
+	if opts.MultiValueSeparator == "" {
+		opts.MultiValueSeparator = defaultMultiValueSeparator
+	}
+
+	var write func(d *InventoryDevice) error
+
+	switch opts.Format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		header := []string{"id"}
+		for _, col := range opts.Columns {
+			header = append(header, col.header())
+		}
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+
+		write = func(d *InventoryDevice) error {
+			row := []string{d.ID}
+			for _, col := range opts.Columns {
+				a, _ := d.Attribute(col.Scope, col.Name)
+				row = append(row, opts.flatten(a.Value))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	case ExportNDJSON:
+		enc := json.NewEncoder(w)
+
+		write = func(d *InventoryDevice) error {
+			record := map[string]interface{}{"id": d.ID}
+			if len(opts.Columns) == 0 {
+				for _, a := range d.Attributes {
+					record[AttributeKey(a.Scope, a.Name)] = opts.ndjsonValue(a.Value)
+				}
+			}
+			for _, col := range opts.Columns {
+				if a, ok := d.Attribute(col.Scope, col.Name); ok {
+					record[col.header()] = opts.ndjsonValue(a.Value)
+				} else {
+					record[col.header()] = nil
+				}
+			}
+			return enc.Encode(record)
+		}
+	default:
+		return 0, fmt.Errorf("Unknown export format %v", opts.Format)
+	}
+
+	count := 0
+	err := c.forEachInventoryPage(opts.Filter, func(devices DeviceInventoryList) error {
+		for i := range devices {
+			if err := write(&devices[i]); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+func (col ExportColumn) header() string {
+	if col.Header != "" {
+		return col.Header
+	}
+
+	return AttributeKey(col.Scope, col.Name)
+}
+
+// Flatten value to single string, missing value is empty
+func (o ExportOptions) flatten(v AttributeValue) string {
+	if len(v.raw) == 0 {
+		return ""
+	}
+
+	if !v.IsArray() {
+		return v.String()
+	}
+
+	switch o.MultiValue {
+	case MultiValueFirst:
+		values, err := v.AsStrings()
+		if err != nil || len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	case MultiValueJSON:
+		return string(v.raw)
+	}
+
+	values, err := v.AsStrings()
+	if err != nil {
+		return string(v.raw)
+	}
+
+	return strings.Join(values, o.MultiValueSeparator)
+}
+
+// Arrays keep json form in MultiValueJSON mode, other values are flattened
+func (o ExportOptions) ndjsonValue(v AttributeValue) interface{} {
+	if o.MultiValue == MultiValueJSON || !v.IsArray() {
+		return v
+	}
+
+	return o.flatten(v)
+}
@@ -0,0 +1,91 @@
+package mender_rest_api_client
+
+import (
+	"bytes"
+	"path"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+const exportInventory = `[
+	{
+	  "id": "1",
+	  "attributes": [
+		{"name": "device_type", "scope": "inventory", "value": "raspberrypi4"},
+		{"name": "ipv4_wlan0", "scope": "inventory", "value": ["10.0.0.2/24", "10.0.0.3/24"]},
+		{"name": "mem_total_kB", "scope": "inventory", "value": 1024}
+	  ]
+	},
+	{
+	  "id": "2",
+	  "attributes": [
+		{"name": "device_type", "scope": "inventory", "value": "qemux86-64"}
+	  ]
+	}
+  ]`
+
+func TestExportInventoryCSV(t *testing.T) {
+	c := restartHttpMock("GET", path.Join(deviceInventoryBasePath, "devices"), "", 200)
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "devices"),
+		firstPageResponder(200, exportInventory))
+
+	var out bytes.Buffer
+	n, e := c.ExportInventory(&out, ExportOptions{
+		Format: ExportCSV,
+		Columns: []ExportColumn{
+			{Scope: ScopeInventory, Name: "device_type", Header: "type"},
+			{Scope: ScopeInventory, Name: "ipv4_wlan0"},
+			{Scope: ScopeInventory, Name: "mem_total_kB"},
+		},
+		MultiValueSeparator: "|",
+	})
+	if e != nil || n != 2 {
+		t.Fatal(e, n)
+	}
+
+	expected := "id,type,inventory/ipv4_wlan0,inventory/mem_total_kB\n" +
+		"1,raspberrypi4,10.0.0.2/24|10.0.0.3/24,1024\n" +
+		"2,qemux86-64,,\n"
+	if out.String() != expected {
+		t.Errorf("Invalid csv:\n%v", out.String())
+	}
+
+	// columns are required
+	if _, e = c.ExportInventory(&out, ExportOptions{Format: ExportCSV}); e == nil {
+		t.Error(e)
+	}
+}
+
+func TestExportInventoryNDJSON(t *testing.T) {
+	c := restartHttpMock("GET", path.Join(deviceInventoryBasePath, "devices"), "", 200)
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "devices"),
+		firstPageResponder(200, exportInventory))
+
+	var out bytes.Buffer
+	n, e := c.ExportInventory(&out, ExportOptions{
+		Format: ExportNDJSON,
+		Columns: []ExportColumn{
+			{Scope: ScopeInventory, Name: "ipv4_wlan0", Header: "ip"},
+		},
+		MultiValue: MultiValueFirst,
+	})
+	if e != nil || n != 2 {
+		t.Fatal(e, n)
+	}
+
+	expected := `{"id":"1","ip":"10.0.0.2/24"}` + "\n" + `{"id":"2","ip":null}` + "\n"
+	if out.String() != expected {
+		t.Errorf("Invalid ndjson:\n%v", out.String())
+	}
+
+	// all attributes
+	out.Reset()
+	c.ExportInventory(&out, ExportOptions{Format: ExportNDJSON, MultiValue: MultiValueJSON})
+
+	expected = `{"id":"1","inventory/device_type":"raspberrypi4","inventory/ipv4_wlan0":["10.0.0.2/24","10.0.0.3/24"],"inventory/mem_total_kB":1024}` + "\n" +
+		`{"id":"2","inventory/device_type":"qemux86-64"}` + "\n"
+	if out.String() != expected {
+		t.Errorf("Invalid ndjson:\n%v", out.String())
+	}
+}
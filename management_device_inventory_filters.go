@@ -0,0 +1,220 @@
+package mender_rest_api_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+)
+
+// base path
+const deviceInventoryV2BasePath = "/api/management/v2/inventory"
+
+// single term of a saved filter, e.g. scope "inventory", attribute "device_type",
+// type "$eq", value "raspberrypi4"
+type FilterPredicate struct {
+	Scope     string      `json:"scope"`
+	Attribute string      `json:"attribute"`
+	Type      string      `json:"type"`
+	Value     interface{} `json:"value"`
+}
+
+type InventoryFilter struct {
+	ID    string            `json:"id,omitempty"`
+	Name  string            `json:"name"`
+	Terms []FilterPredicate `json:"terms"`
+}
+
+// Attribute usable in filters with number of devices having it
+type FilterAttribute struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	Count int    `json:"count"`
+}
+
+type filterSearch struct {
+	Page    int               `json:"page"`
+	PerPage int               `json:"per_page"`
+	Filters []FilterPredicate `json:"filters"`
+}
+
+// Create saved filter (dynamic group), returns id of the new filter
+func (c *Client) CreateFilter(name string, terms []FilterPredicate) (string, error) {
+	filter := InventoryFilter{
+		Name:  name,
+		Terms: terms,
+	}
+
+	f, err := json.Marshal(filter)
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshall filter %v", err)
+	}
+
+	resp, err := c.client.R().SetBody(f).Post(path.Join(deviceInventoryV2BasePath, "filters"))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return "", err
+	}
+
+	return idFromLocation(resp)
+}
+
+// List saved filters
+func (c *Client) ListFilters() ([]InventoryFilter, error) {
+	var filters []InventoryFilter = []InventoryFilter{}
+	resp, err := c.client.R().Get(path.Join(deviceInventoryV2BasePath, "filters"))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return filters, err
+	}
+
+	if err = json.Unmarshal(resp.Body(), &filters); err != nil {
+		return filters, err
+	}
+
+	return filters, nil
+}
+
+// Get saved filter
+func (c *Client) GetFilter(filterId string) (InventoryFilter, error) {
+	var filter InventoryFilter = InventoryFilter{}
+	resp, err := c.client.R().Get(path.Join(deviceInventoryV2BasePath, "filters", filterId))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return filter, err
+	}
+
+	if err = json.Unmarshal(resp.Body(), &filter); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// Delete saved filter
+func (c *Client) DeleteFilter(filterId string) error {
+	resp, err := c.client.R().Delete(path.Join(deviceInventoryV2BasePath, "filters", filterId))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Resolve saved filter to ids of matching devices
+func (c *Client) GetDevicesInFilter(filterId string) ([]string, error) {
+	filter, err := c.GetFilter(filterId)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return c.SearchDeviceIDs(filter.Terms)
+}
+
+// Search ids of devices matching filter terms, all pages are fetched
+func (c *Client) SearchDeviceIDs(terms []FilterPredicate) ([]string, error) {
+	var ids []string = []string{}
+
+	for page := 1; ; page++ {
+		var devices []struct {
+			ID string `json:"id"`
+		}
+
+		search := filterSearch{
+			Page:    page,
+			PerPage: pageSize,
+			Filters: terms,
+		}
+
+		s, err := json.Marshal(search)
+		if err != nil {
+			return ids, fmt.Errorf("Failed to marshall search %v", err)
+		}
+
+		resp, err := c.client.R().SetBody(s).Post(path.Join(deviceInventoryV2BasePath, "filters/search"))
+		if err = checkAndReturnError(resp, err); err != nil {
+			return ids, err
+		}
+
+		if err = json.Unmarshal(resp.Body(), &devices); err != nil {
+			return ids, err
+		}
+
+		if len(devices) == 0 {
+			break
+		}
+
+		for _, d := range devices {
+			ids = append(ids, d.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+// List filterable attributes
+func (c *Client) ListFilterAttributes() ([]FilterAttribute, error) {
+	var attributes []FilterAttribute = []FilterAttribute{}
+	resp, err := c.client.R().Get(path.Join(deviceInventoryV2BasePath, "filters/attributes"))
+	if err = checkAndReturnError(resp, err); err != nil {
+		return attributes, err
+	}
+
+	if err = json.Unmarshal(resp.Body(), &attributes); err != nil {
+		return attributes, err
+	}
+
+	return attributes, nil
+}
+
+// List filterable attributes, computed from inventory of all devices
+// when server doesn't support filter attributes endpoint
+func (c *Client) DiscoverFilterAttributes() ([]FilterAttribute, error) {
+	attributes, err := c.ListFilterAttributes()
+	if respErr, ok := err.(*ResponseError); ok &&
+		(respErr.StatusCode == http.StatusNotFound || respErr.StatusCode == http.StatusMethodNotAllowed) {
+		return c.ScanFilterAttributes()
+	}
+
+	return attributes, err
+}
+
+// Compute filterable attributes from inventory of all devices,
+// sorted by count, scope and name
+func (c *Client) ScanFilterAttributes() ([]FilterAttribute, error) {
+	var attributes []FilterAttribute = []FilterAttribute{}
+	counts := map[FilterAttribute]int{}
+
+	err := c.forEachInventoryPage(nil, func(devices DeviceInventoryList) error {
+		for _, d := range devices {
+			seen := map[FilterAttribute]bool{}
+			for _, a := range d.Attributes {
+				key := FilterAttribute{Name: a.Name, Scope: a.Scope}
+				if !seen[key] {
+					seen[key] = true
+					counts[key]++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return attributes, err
+	}
+
+	for key, count := range counts {
+		key.Count = count
+		attributes = append(attributes, key)
+	}
+
+	sort.Slice(attributes, func(i, j int) bool {
+		a, b := attributes[i], attributes[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		return a.Name < b.Name
+	})
+
+	return attributes, nil
+}
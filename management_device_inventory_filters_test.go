@@ -0,0 +1,105 @@
+package mender_rest_api_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestCreateFilter(t *testing.T) {
+	c := restartHttpMock("POST", path.Join(deviceInventoryV2BasePath, "filters"), "", 201)
+	httpmock.RegisterResponder("POST", path.Join(deviceInventoryV2BasePath, "filters"),
+		func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(201, "")
+			resp.Header.Set("Location", path.Join(deviceInventoryV2BasePath, "filters", "abcd"))
+			return resp, nil
+		})
+
+	id, e := c.CreateFilter("rpi4", []FilterPredicate{{Scope: "inventory", Attribute: "device_type", Type: "$eq", Value: "raspberrypi4"}})
+	if e != nil || id != "abcd" {
+		t.Error(e, id)
+	}
+
+	// error response
+	c = restartHttpMock("POST", path.Join(deviceInventoryV2BasePath, "filters"), `{"error": "conflict"}`, 409)
+	_, e = c.CreateFilter("rpi4", nil)
+	if e == nil {
+		t.Error(e)
+	}
+}
+
+func TestGetDevicesInFilter(t *testing.T) {
+	filterId := "abcd"
+	c := restartHttpMock("GET", path.Join(deviceInventoryV2BasePath, "filters", filterId), `{
+		"id": "abcd",
+		"name": "rpi4",
+		"terms": [{"scope": "inventory", "attribute": "device_type", "type": "$eq", "value": "raspberrypi4"}]
+	}`, 200)
+	httpmock.RegisterResponder("POST", path.Join(deviceInventoryV2BasePath, "filters/search"),
+		func(req *http.Request) (*http.Response, error) {
+			var search filterSearch
+			if e := json.NewDecoder(req.Body).Decode(&search); e != nil || search.Page > 2 {
+				return httpmock.NewStringResponse(200, `[]`), nil
+			}
+			return httpmock.NewStringResponse(200, fmt.Sprintf(`[{"id": "%v"}]`, search.Page)), nil
+		})
+
+	ids, e := c.GetDevicesInFilter(filterId)
+	if e != nil {
+		t.Error(e)
+	}
+
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "2" {
+		t.Errorf("Invalid data")
+	}
+
+	// filter not exists
+	c = restartHttpMock("GET", path.Join(deviceInventoryV2BasePath, "filters", filterId), `{}`, 404)
+	_, e = c.GetDevicesInFilter(filterId)
+	if e == nil {
+		t.Error(e)
+	}
+}
+
+func TestDiscoverFilterAttributes(t *testing.T) {
+	c := restartHttpMock("GET", path.Join(deviceInventoryV2BasePath, "filters/attributes"),
+		`[{"name": "device_type", "scope": "inventory", "count": 10}]`, 200)
+
+	a, e := c.DiscoverFilterAttributes()
+	if e != nil || len(a) != 1 || a[0].Count != 10 {
+		t.Error(e, a)
+	}
+
+	// fallback to inventory scan
+	c = restartHttpMock("GET", path.Join(deviceInventoryV2BasePath, "filters/attributes"), `{}`, 404)
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "devices"),
+		firstPageResponder(200, `[
+			{"id": "1", "attributes": [
+				{"name": "device_type", "scope": "inventory", "value": "raspberrypi4"},
+				{"name": "location", "scope": "tags", "value": "berlin"}
+			]},
+			{"id": "2", "attributes": [
+				{"name": "device_type", "scope": "inventory", "value": "raspberrypi4"}
+			]}
+		]`))
+
+	a, e = c.DiscoverFilterAttributes()
+	if e != nil || len(a) != 2 {
+		t.Fatal(e, a)
+	}
+
+	if a[0] != (FilterAttribute{Name: "device_type", Scope: "inventory", Count: 2}) ||
+		a[1] != (FilterAttribute{Name: "location", Scope: "tags", Count: 1}) {
+		t.Error(a)
+	}
+
+	// other errors are returned
+	c = restartHttpMock("GET", path.Join(deviceInventoryV2BasePath, "filters/attributes"), `{}`, 500)
+	if _, e = c.DiscoverFilterAttributes(); e == nil {
+		t.Error(e)
+	}
+}
@@ -0,0 +1,226 @@
+package mender_rest_api_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// version of snapshot file format
+const SnapshotVersion = 1
+
+// attributes changing on every inventory update or reported as group moves
+var snapshotIgnoredAttributes = map[string]bool{
+	AttributeKey(ScopeSystem, "group"):      true,
+	AttributeKey(ScopeSystem, "updated_ts"): true,
+	AttributeKey(ScopeSystem, "created_ts"): true,
+}
+
+// Point in time state of whole fleet
+type FleetSnapshot struct {
+	Version   int                       `json:"version"`
+	CreatedAt time.Time                 `json:"created_at"`
+	Devices   map[string]SnapshotDevice `json:"devices"`
+}
+
+type SnapshotDevice struct {
+	Status string `json:"status"`
+	Group  string `json:"group"`
+	// keys are created by AttributeKey
+	Attributes map[string]AttributeValue `json:"attributes"`
+}
+
+// Attribute change, Old is nil for added and New is nil for removed attribute
+type AttributeChange struct {
+	Key string          `json:"key"`
+	Old *AttributeValue `json:"old"`
+	New *AttributeValue `json:"new"`
+}
+
+type GroupMove struct {
+	DeviceId string `json:"device_id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+type StatusChange struct {
+	DeviceId string `json:"device_id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// Differences between two snapshots
+type SnapshotDiff struct {
+	Added         []string                     `json:"added"`
+	Removed       []string                     `json:"removed"`
+	Changed       map[string][]AttributeChange `json:"changed"`
+	GroupMoves    []GroupMove                  `json:"group_moves"`
+	StatusChanges []StatusChange               `json:"status_changes"`
+}
+
+// Capture inventory, authentication status and group of all devices
+func (c *Client) TakeSnapshot() (FleetSnapshot, error) {
+	snapshot := FleetSnapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Devices:   map[string]SnapshotDevice{},
+	}
+
+	err := c.forEachInventoryPage(nil, func(devices DeviceInventoryList) error {
+		for i := range devices {
+			snapshot.Devices[devices[i].ID] = SnapshotDevice{
+				Group:      devices[i].Group(),
+				Attributes: devices[i].ToMap(),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return snapshot, err
+	}
+
+	for page := 1; ; page++ {
+		devices, err := c.listDevicesPage(page, pageSize)
+		if err != nil {
+			return snapshot, err
+		}
+
+		if len(devices) == 0 {
+			break
+		}
+
+		for _, d := range devices {
+			device, ok := snapshot.Devices[d.ID]
+			if !ok {
+				device.Attributes = map[string]AttributeValue{}
+			}
+			device.Status = d.Status
+			snapshot.Devices[d.ID] = device
+		}
+	}
+
+	return snapshot, nil
+}
+
+// Save snapshot to json file
+func (s FleetSnapshot) Save(filePath string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filePath, data, 0644)
+}
+
+// Load snapshot from json file
+func LoadSnapshot(filePath string) (FleetSnapshot, error) {
+	var snapshot FleetSnapshot = FleetSnapshot{}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return snapshot, fmt.Errorf("Failed to read file: %v", err)
+	}
+
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.Version != SnapshotVersion {
+		return snapshot, fmt.Errorf("Unsupported snapshot version %v", snapshot.Version)
+	}
+
+	return snapshot, nil
+}
+
+// Compare two snapshots, all lists are sorted by device id
+func DiffSnapshots(from, to FleetSnapshot) SnapshotDiff {
+	diff := SnapshotDiff{
+		Added:         []string{},
+		Removed:       []string{},
+		Changed:       map[string][]AttributeChange{},
+		GroupMoves:    []GroupMove{},
+		StatusChanges: []StatusChange{},
+	}
+
+	for _, id := range sortedDeviceIds(from.Devices) {
+		if _, ok := to.Devices[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	for _, id := range sortedDeviceIds(to.Devices) {
+		newDevice := to.Devices[id]
+		oldDevice, ok := from.Devices[id]
+		if !ok {
+			diff.Added = append(diff.Added, id)
+			continue
+		}
+
+		if oldDevice.Group != newDevice.Group {
+			diff.GroupMoves = append(diff.GroupMoves, GroupMove{DeviceId: id, From: oldDevice.Group, To: newDevice.Group})
+		}
+
+		if oldDevice.Status != newDevice.Status {
+			diff.StatusChanges = append(diff.StatusChanges, StatusChange{DeviceId: id, From: oldDevice.Status, To: newDevice.Status})
+		}
+
+		if changes := diffAttributes(oldDevice.Attributes, newDevice.Attributes); len(changes) > 0 {
+			diff.Changed[id] = changes
+		}
+	}
+
+	return diff
+}
+
+// Check if snapshots are same
+func (d SnapshotDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 &&
+		len(d.GroupMoves) == 0 && len(d.StatusChanges) == 0
+}
+
+func diffAttributes(from, to map[string]AttributeValue) []AttributeChange {
+	keys := map[string]bool{}
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		if !snapshotIgnoredAttributes[k] {
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+
+	changes := []AttributeChange{}
+	for _, k := range sorted {
+		oldValue, inOld := from[k]
+		newValue, inNew := to[k]
+
+		switch {
+		case !inOld:
+			changes = append(changes, AttributeChange{Key: k, New: &newValue})
+		case !inNew:
+			changes = append(changes, AttributeChange{Key: k, Old: &oldValue})
+		case !oldValue.Equal(newValue):
+			changes = append(changes, AttributeChange{Key: k, Old: &oldValue, New: &newValue})
+		}
+	}
+
+	return changes
+}
+
+func sortedDeviceIds(devices map[string]SnapshotDevice) []string {
+	ids := make([]string, 0, len(devices))
+	for id := range devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
@@ -0,0 +1,103 @@
+package mender_rest_api_client
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestTakeSnapshot(t *testing.T) {
+	c := restartHttpMock("GET", path.Join(deviceInventoryBasePath, "devices"), "", 200)
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "devices"),
+		firstPageResponder(200, `[
+		{
+		  "id": "1",
+		  "attributes": [
+			{"name": "artifact_name", "scope": "inventory", "value": "release-1"},
+			{"name": "group", "scope": "system", "value": "production"}
+		  ]
+		}
+	  ]`))
+	httpmock.RegisterResponder("GET", path.Join(deviceAuthBasePath, "devices"),
+		firstPageResponder(200, `[{"id": "1", "status": "accepted"}, {"id": "2", "status": "pending"}]`))
+
+	s, e := c.TakeSnapshot()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(s.Devices) != 2 || s.Devices["1"].Group != "production" || s.Devices["1"].Status != "accepted" ||
+		s.Devices["2"].Status != "pending" {
+		t.Error(s)
+	}
+
+	dir, e := ioutil.TempDir("", "snapshot")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "snapshot.json")
+	if e = s.Save(file); e != nil {
+		t.Fatal(e)
+	}
+
+	loaded, e := LoadSnapshot(file)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if d := DiffSnapshots(s, loaded); !d.Empty() {
+		t.Error(d)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	value := func(v interface{}) AttributeValue {
+		a, _ := NewAttributeValue(v)
+		return a
+	}
+
+	old := FleetSnapshot{Version: SnapshotVersion, Devices: map[string]SnapshotDevice{
+		"1": {Status: "accepted", Group: "beta", Attributes: map[string]AttributeValue{
+			"inventory/artifact_name": value("release-1"),
+			"inventory/mem_total_kB":  value(1024),
+			"inventory/kernel":        value("5.4"),
+		}},
+		"2": {Status: "accepted"},
+	}}
+	new := FleetSnapshot{Version: SnapshotVersion, Devices: map[string]SnapshotDevice{
+		"1": {Status: "accepted", Group: "production", Attributes: map[string]AttributeValue{
+			"inventory/artifact_name": value("release-2"),
+			"inventory/mem_total_kB":  value(1024),
+			"inventory/hostname":      value("rpi"),
+		}},
+		"3": {Status: "pending"},
+	}}
+
+	d := DiffSnapshots(old, new)
+
+	if len(d.Added) != 1 || d.Added[0] != "3" || len(d.Removed) != 1 || d.Removed[0] != "2" {
+		t.Error(d.Added, d.Removed)
+	}
+
+	if len(d.GroupMoves) != 1 || d.GroupMoves[0].From != "beta" || d.GroupMoves[0].To != "production" {
+		t.Error(d.GroupMoves)
+	}
+
+	changes := d.Changed["1"]
+	if len(changes) != 3 {
+		t.Fatal(changes)
+	}
+
+	// sorted by key
+	if changes[0].Key != "inventory/artifact_name" || changes[0].New.String() != "release-2" ||
+		changes[1].Key != "inventory/hostname" || changes[1].Old != nil ||
+		changes[2].Key != "inventory/kernel" || changes[2].New != nil {
+		t.Error(changes)
+	}
+}
@@ -0,0 +1,342 @@
+package mender_rest_api_client
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestAttributeValue(t *testing.T) {
+	var attrs []struct {
+		Name  string         `json:"name"`
+		Value AttributeValue `json:"value"`
+	}
+
+	data := `[
+		{"name": "hostname", "value": "rpi"},
+		{"name": "mem_total_kB", "value": 1024},
+		{"name": "ipv4_wlan0", "value": ["10.0.0.2/24", "10.0.0.3/24"]},
+		{"name": "rootfs_rw", "value": true},
+		{"name": "disk_size", "value": 9007199254740993},
+		{"name": "kernel", "value": null}
+	]`
+
+	if e := json.Unmarshal([]byte(data), &attrs); e != nil {
+		t.Fatal(e)
+	}
+
+	if s, e := attrs[0].Value.AsString(); e != nil || s != "rpi" {
+		t.Error(e, s)
+	}
+
+	if i, e := attrs[1].Value.AsInt(); e != nil || i != 1024 {
+		t.Error(e, i)
+	}
+
+	if s, e := attrs[1].Value.AsString(); e != nil || s != "1024" {
+		t.Error(e, s)
+	}
+
+	if l, e := attrs[2].Value.AsStrings(); e != nil || len(l) != 2 || l[1] != "10.0.0.3/24" {
+		t.Error(e, l)
+	}
+
+	if _, e := attrs[2].Value.AsString(); e == nil {
+		t.Error("Array converted to string")
+	}
+
+	if b, e := attrs[3].Value.AsBool(); e != nil || !b {
+		t.Error(e, b)
+	}
+
+	if _, e := attrs[0].Value.AsInt(); e == nil {
+		t.Error("String converted to int")
+	}
+
+	if i, e := attrs[4].Value.AsInt(); e != nil || i != 9007199254740993 {
+		t.Error(e, i)
+	}
+
+	if s := attrs[4].Value.String(); s != "9007199254740993" {
+		t.Error(s)
+	}
+
+	if s := attrs[5].Value.String(); s != "" {
+		t.Error(s)
+	}
+
+	// re-encode
+	out, e := json.Marshal(attrs)
+	if e != nil {
+		t.Error(e)
+	}
+
+	var orig, encoded interface{}
+	json.Unmarshal([]byte(data), &orig)
+	json.Unmarshal(out, &encoded)
+	o, _ := json.Marshal(orig)
+	n, _ := json.Marshal(encoded)
+	if string(o) != string(n) {
+		t.Errorf("Value changed after encoding: %s", out)
+	}
+}
+
+func TestListDeviceInventories(t *testing.T) {
+	c := restartHttpMock("GET", path.Join(deviceInventoryBasePath, "devices"), `[
+		{
+		  "id": "1",
+		  "attributes": [
+			{"name": "device_type", "scope": "inventory", "value": "raspberrypi4"},
+			{"name": "mem_total_kB", "scope": "inventory", "value": 3884344},
+			{"name": "ipv4_wlan0", "scope": "inventory", "value": ["10.0.0.2/24"]}
+		  ],
+		  "updated_ts": "2019-08-24T14:15:22Z"
+		}
+	  ]`, 200)
+
+	d, e := c.ListDeviceInventories(nil)
+	if e != nil {
+		t.Error(e)
+	}
+
+	if len(d) != 1 || d[0].Attributes[1].Value.String() != "3884344" {
+		t.Errorf("Invalid data")
+	}
+
+	// query parameters
+	hasGroup := true
+	opts := &InventoryListOptions{
+		Page:       2,
+		PerPage:    50,
+		Sort:       "hostname:asc",
+		HasGroup:   &hasGroup,
+		Group:      "production",
+		Attributes: map[string]string{"device_type": "raspberrypi4"},
+	}
+	c = restartHttpMock("GET", path.Join(deviceInventoryBasePath, "devices"), `[]`, 200)
+	httpmock.RegisterResponderWithQuery("GET", path.Join(deviceInventoryBasePath, "devices"),
+		"page=2&per_page=50&sort=hostname:asc&has_group=true&group=production&device_type=raspberrypi4",
+		httpmock.NewStringResponder(200, `[{"id": "2"}]`))
+
+	d, e = c.ListDeviceInventories(opts)
+	if e != nil || len(d) != 1 || d[0].ID != "2" {
+		t.Error(e, d)
+	}
+
+	// attribute filter can't override paging
+	opts.Attributes["page"] = "1"
+	if _, e = c.ListDeviceInventories(opts); e == nil {
+		t.Error(e)
+	}
+
+	// error response
+	c = restartHttpMock("GET", path.Join(deviceInventoryBasePath, "devices"), `{}`, 500)
+	_, e = c.ListDeviceInventories(nil)
+	if e == nil {
+		t.Error(e)
+	}
+}
+
+func TestDeviceTags(t *testing.T) {
+	deviceId := "1"
+	c := restartHttpMock("GET", path.Join(deviceInventoryBasePath, "devices", deviceId), "", 200)
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "devices", deviceId),
+		func(req *http.Request) (*http.Response, error) {
+			resp, _ := httpmock.NewJsonResponse(200, map[string]interface{}{
+				"id": deviceId,
+				"attributes": []map[string]string{
+					{"name": "device_type", "scope": "inventory", "value": "raspberrypi4"},
+					{"name": "location", "scope": "tags", "value": "berlin", "description": "site"},
+				},
+			})
+			resp.Header.Set("ETag", "etag-1")
+			return resp, nil
+		})
+
+	tags, etag, e := c.GetDeviceTags(deviceId)
+	if e != nil || etag != "etag-1" {
+		t.Error(e, etag)
+	}
+
+	if len(tags) != 1 || tags[0].Name != "location" || tags[0].Value != "berlin" {
+		t.Errorf("Invalid data")
+	}
+
+	// etag is sent
+	httpmock.RegisterResponder("PUT", path.Join(deviceInventoryBasePath, "devices", deviceId, "tags"),
+		func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("If-Match") != "etag-1" {
+				return httpmock.NewStringResponse(412, ""), nil
+			}
+			return httpmock.NewStringResponse(200, ""), nil
+		})
+
+	if e = c.SetDeviceTags(deviceId, tags, etag); e != nil {
+		t.Error(e)
+	}
+
+	// concurrent modification
+	e = c.SetDeviceTags(deviceId, tags, "etag-0")
+	if _, ok := e.(*PreconditionFailedError); !ok {
+		t.Error(e)
+	}
+
+	// bulk tagging
+	c = restartHttpMock("PATCH", path.Join(deviceInventoryBasePath, "devices", "1", "tags"), "", 200)
+	httpmock.RegisterResponder("PATCH", path.Join(deviceInventoryBasePath, "devices", "2", "tags"),
+		httpmock.NewStringResponder(404, `{"error": "device not found"}`))
+
+	failed := c.TagDevices([]string{"1", "2"}, tags)
+	if len(failed) != 1 || failed["2"] == nil {
+		t.Error(failed)
+	}
+}
+
+func TestInventoryDeviceAttributes(t *testing.T) {
+	c := restartHttpMock("GET", path.Join(deviceInventoryBasePath, "devices", "1"), `{
+		"id": "1",
+		"attributes": [
+		  {"name": "device_type", "scope": "inventory", "value": "raspberrypi4"},
+		  {"name": "artifact_name", "scope": "inventory", "value": "release-1"},
+		  {"name": "group", "scope": "system", "value": "production"},
+		  {"name": "mac", "scope": "identity", "value": "00:01:02:03:04:05"}
+		]
+	  }`, 200)
+
+	d, e := c.GetDeviceInventory("1")
+	if e != nil {
+		t.Error(e)
+	}
+
+	if d.DeviceType() != "raspberrypi4" || d.ArtifactName() != "release-1" || d.Group() != "production" {
+		t.Errorf("Invalid data")
+	}
+
+	if d.Hostname() != "" {
+		t.Errorf("Missing attribute found")
+	}
+
+	if _, ok := d.Attribute(ScopeInventory, "mac"); ok {
+		t.Errorf("Attribute found in wrong scope")
+	}
+
+	m := d.ToMap()
+	if len(m) != 4 || m[AttributeKey(ScopeIdentity, "mac")].String() != "00:01:02:03:04:05" {
+		t.Error(m)
+	}
+
+	// lookup follows in-place changes of attributes
+	d.Attributes[0], d.Attributes[1] = d.Attributes[1], d.Attributes[0]
+	d.Attributes[1].Value, e = NewAttributeValue("beaglebone")
+	if e != nil {
+		t.Error(e)
+	}
+	if d.DeviceType() != "beaglebone" || d.ArtifactName() != "release-1" {
+		t.Errorf("Invalid data after attributes change")
+	}
+}
+
+func TestRenameGroup(t *testing.T) {
+	c := restartHttpMock("GET", path.Join(deviceInventoryBasePath, "groups", "old", "devices"), "", 200)
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "groups", "old", "devices"),
+		firstPageResponder(200, `["1", "2", "3"]`))
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "groups", "new", "devices"),
+		httpmock.NewStringResponder(404, `{"error": "group not found"}`))
+	httpmock.RegisterResponder("PATCH", path.Join(deviceInventoryBasePath, "groups", "new", "devices"),
+		httpmock.NewStringResponder(200, `{"updated_count": 3, "matched_count": 3}`))
+
+	calls := 0
+	r, e := c.RenameGroup("old", "new", func(done, total int) {
+		calls++
+		if total != 3 || done != 3 {
+			t.Errorf("Invalid progress %v/%v", done, total)
+		}
+	})
+	if e != nil {
+		t.Error(e)
+	}
+
+	if len(r.Moved) != 3 || len(r.Failed) != 0 || calls != 1 {
+		t.Error(r)
+	}
+
+	if n := httpmock.GetTotalCallCount(); n != 4 {
+		t.Errorf("Unexpected number of requests %v", n)
+	}
+
+	// failed chunk
+	httpmock.RegisterResponder("PATCH", path.Join(deviceInventoryBasePath, "groups", "new", "devices"),
+		httpmock.NewStringResponder(500, `{"error": "internal error"}`))
+
+	r, e = c.RenameGroup("old", "new", nil)
+	if e == nil || len(r.Moved) != 0 || r.Failed["2"] == nil {
+		t.Error(e, r)
+	}
+
+	// target group exists
+	c = restartHttpMock("GET", path.Join(deviceInventoryBasePath, "groups", "new", "devices"), "", 200)
+	httpmock.RegisterResponder("GET", path.Join(deviceInventoryBasePath, "groups", "new", "devices"),
+		firstPageResponder(200, `["4"]`))
+	_, e = c.RenameGroup("old", "new", nil)
+	if e == nil {
+		t.Error(e)
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	c := restartHttpMock("DELETE", path.Join(deviceInventoryBasePath, "groups", "old"), `{"updated_count": 2}`, 200)
+	if e := c.DeleteGroup("old"); e != nil {
+		t.Error(e)
+	}
+
+	c = restartHttpMock("DELETE", path.Join(deviceInventoryBasePath, "groups", "old"), `{}`, 404)
+	if e := c.DeleteGroup("old"); e == nil {
+		t.Error(e)
+	}
+}
+
+func TestAddDevicesToGroup(t *testing.T) {
+	devices := make([]string, 2500)
+	for i := range devices {
+		devices[i] = strconv.Itoa(i)
+	}
+
+	c := restartHttpMock("PATCH", path.Join(deviceInventoryBasePath, "groups", "new", "devices"), "", 200)
+	httpmock.RegisterResponder("PATCH", path.Join(deviceInventoryBasePath, "groups", "new", "devices"),
+		func(req *http.Request) (*http.Response, error) {
+			var chunk []string
+			if e := json.NewDecoder(req.Body).Decode(&chunk); e != nil {
+				return httpmock.NewStringResponse(400, ""), nil
+			}
+			if len(chunk) > groupUpdateChunkSize {
+				return httpmock.NewStringResponse(400, `{"error": "too many devices"}`), nil
+			}
+			return httpmock.NewJsonResponse(200, map[string]int{"updated_count": len(chunk), "matched_count": len(chunk)})
+		})
+
+	r, e := c.AddDevicesToGroup("new", devices)
+	if e != nil || r.UpdatedCount != 2500 || r.MatchedCount != 2500 {
+		t.Error(e, r)
+	}
+
+	// failed chunk
+	c = restartHttpMock("DELETE", path.Join(deviceInventoryBasePath, "groups", "new", "devices"), "", 200)
+	httpmock.RegisterResponder("DELETE", path.Join(deviceInventoryBasePath, "groups", "new", "devices"),
+		func(req *http.Request) (*http.Response, error) {
+			var chunk []string
+			json.NewDecoder(req.Body).Decode(&chunk)
+			if chunk[0] == "1000" {
+				return httpmock.NewStringResponse(500, ""), nil
+			}
+			return httpmock.NewJsonResponse(200, map[string]int{"updated_count": len(chunk)})
+		})
+
+	r, e = c.RemoveDevicesFromGroup("new", devices)
+	if e == nil || r.UpdatedCount != 1500 || len(r.Errors) != 1 || r.Errors[0].Devices[0] != "1000" {
+		t.Error(e, r)
+	}
+}
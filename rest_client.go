@@ -2,10 +2,16 @@ package mender_rest_api_client
 
 import (
 	"crypto/tls"
+	"fmt"
+	"path"
 
 	"github.com/go-resty/resty/v2"
 )
 
+// number of items requested per page when all pages of a list are fetched,
+// fetching stops on first empty page
+const pageSize = 500
+
 type Client struct {
 	jwtToken      string
 	username      string
@@ -59,3 +65,42 @@ func (c *Client) Login(debug, skipTlsVerify bool) error {
 
 	return nil
 }
+
+// Error response from server
+type ResponseError struct {
+	StatusCode int
+	Message    string
+	// known meaning of status code for called endpoint, can be nil
+	Err error
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Error response:%v", e.StatusCode)
+	}
+
+	return fmt.Sprintf("Error response:%v %v", e.StatusCode, e.Message)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// Attach known meaning of status code to response error
+func classifyError(err error, known map[int]error) error {
+	if respErr, ok := err.(*ResponseError); ok {
+		respErr.Err = known[respErr.StatusCode]
+	}
+
+	return err
+}
+
+// Get id of created resource from Location header
+func idFromLocation(resp *resty.Response) (string, error) {
+	location := resp.Header().Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("Missing location header")
+	}
+
+	return path.Base(location), nil
+}